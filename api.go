@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const API_VERSION = "1.12"
+
+var (
+	DOCKER_TIMEOUT      time.Duration = 2 * time.Minute
+	DOCKER_DIAL_TIMEOUT time.Duration = 30 * time.Second
+)
+
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("API error (%d): %s", e.Status, e.Message)
+}
+
+func dockerEndpoint() string {
+	endpoint := os.Getenv("DOCKER_HOST")
+	if len(endpoint) == 0 {
+		endpoint = "unix:///var/run/docker.sock"
+	}
+	return endpoint
+}
+
+/*
+ * dockerTLSConfig follows the docker client, DOCKER_TLS_VERIFY turns on TLS
+ * and checks the daemon against ca.pem in DOCKER_CERT_PATH (~/.docker by
+ * default).  With only DOCKER_CERT_PATH TLS is used without checking the
+ * daemon.  cert.pem and key.pem are sent if they are there.
+ */
+func dockerTLSConfig() (*tls.Config, error) {
+	verify := len(os.Getenv("DOCKER_TLS_VERIFY")) > 0
+	certPath := os.Getenv("DOCKER_CERT_PATH")
+	if !verify && len(certPath) == 0 {
+		return nil, nil
+	}
+	if len(certPath) == 0 {
+		certPath = path.Join(os.Getenv("HOME"), ".docker")
+	}
+
+	config := &tls.Config{
+		InsecureSkipVerify: !verify,
+	}
+
+	certFile, keyFile := path.Join(certPath, "cert.pem"), path.Join(certPath, "key.pem")
+	if _, err := os.Stat(certFile); err == nil {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if verify {
+		ca, err := ioutil.ReadFile(path.Join(certPath, "ca.pem"))
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("No certificates found in %s", path.Join(certPath, "ca.pem"))
+		}
+	}
+
+	return config, nil
+}
+
+/* dockerTransport connects to DOCKER_HOST, it returns the base URL to use with it */
+func dockerTransport() (*http.Transport, string, error) {
+	u, err := url.Parse(dockerEndpoint())
+	if err != nil {
+		return nil, "", err
+	}
+
+	dialer := &net.Dialer{Timeout: DOCKER_DIAL_TIMEOUT}
+	transport := &http.Transport{
+		Dial:                dialer.Dial,
+		TLSHandshakeTimeout: DOCKER_DIAL_TIMEOUT,
+	}
+
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport.Dial = func(network, addr string) (net.Conn, error) {
+			return dialer.Dial("unix", socket)
+		}
+		return transport, "http://docker", nil
+	case "tcp", "http", "https":
+		transport.TLSClientConfig, err = dockerTLSConfig()
+		if err != nil {
+			return nil, "", err
+		}
+		if transport.TLSClientConfig != nil || u.Scheme == "https" {
+			return transport, "https://" + u.Host, nil
+		}
+		return transport, "http://" + u.Host, nil
+	default:
+		return nil, "", fmt.Errorf("Unsupported DOCKER_HOST %s", u.String())
+	}
+}
+
+/*
+ * dockerDo sends a raw request to the Docker API, turning error statuses
+ * into an apiError.  timeout covers the whole request, reading the body
+ * included, 0 means no limit for calls that stream.
+ */
+func dockerDo(method string, path string, body io.Reader, contentType string, timeout time.Duration) (*http.Response, error) {
+	transport, base, err := dockerTransport()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/v%s%s", base, API_VERSION, path), body)
+	if err != nil {
+		return nil, err
+	}
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return nil, &apiError{
+			Status:  resp.StatusCode,
+			Message: strings.TrimSpace(string(data)),
+		}
+	}
+
+	return resp, nil
+}
+
+/*
+ * dockerRequest calls the Docker API directly for the few things the
+ * vendored client can't do, like creating a container with labels.  It
+ * gives up after DOCKER_TIMEOUT.
+ */
+func dockerRequest(method string, path string, in interface{}, out interface{}) error {
+	return dockerRequestTimeout(method, path, in, out, DOCKER_TIMEOUT)
+}
+
+func dockerRequestTimeout(method string, path string, in interface{}, out interface{}, timeout time.Duration) error {
+	body := &bytes.Buffer{}
+	contentType := ""
+	if in != nil {
+		if err := json.NewEncoder(body).Encode(in); err != nil {
+			return err
+		}
+		contentType = "application/json"
+	}
+
+	resp, err := dockerDo(method, path, body, contentType, timeout)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if out != nil && len(data) > 0 {
+		return json.Unmarshal(data, out)
+	}
+
+	return nil
+}
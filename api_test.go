@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestDockerRequest(t *testing.T) {
+	unexpected := ""
+	_, done := fakeDocker(t, map[string]http.HandlerFunc{
+		"/containers/create": func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" || r.URL.Query().Get("name") != "web" {
+				unexpected = r.Method + " " + r.URL.String()
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"Id":"abc"}`))
+		},
+		"/containers/missing/json": func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "No such container: missing", http.StatusNotFound)
+		},
+	})
+	defer done()
+
+	out := struct{ Id string }{}
+	if err := dockerRequest("POST", "/containers/create?name=web", map[string]string{}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(unexpected) > 0 {
+		t.Fatal("unexpected request", unexpected)
+	}
+	if out.Id != "abc" {
+		t.Fatal("bad id", out.Id)
+	}
+
+	err := dockerRequest("GET", "/containers/missing/json", nil, nil)
+	if e, ok := err.(*apiError); !ok || e.Status != 404 || e.Message != "No such container: missing" {
+		t.Fatal("expected a 404 api error", err)
+	}
+}
+
+func TestDockerRequestTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ApiVersion":"1.12"}`))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	ioutil.WriteFile(path.Join(dir, "ca.pem"), ca, 0644)
+
+	for name, value := range map[string]string{
+		"DOCKER_HOST":       "tcp://" + server.Listener.Addr().String(),
+		"DOCKER_TLS_VERIFY": "1",
+		"DOCKER_CERT_PATH":  dir,
+	} {
+		old, set := os.LookupEnv(name)
+		os.Setenv(name, value)
+		if set {
+			defer os.Setenv(name, old)
+		} else {
+			defer os.Unsetenv(name)
+		}
+	}
+
+	out := struct{ ApiVersion string }{}
+	if err := dockerRequest("GET", "/version", nil, &out); err != nil || out.ApiVersion != "1.12" {
+		t.Fatal("should talk TLS to the daemon", out, err)
+	}
+
+	client, err := getClient(&Context{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Version(); err != nil {
+		t.Fatal("the client should talk TLS too", err)
+	}
+
+	/* Checked against ca.pem */
+	ioutil.WriteFile(path.Join(dir, "ca.pem"), []byte{}, 0644)
+	if err := dockerRequest("GET", "/version", nil, &out); err == nil {
+		t.Fatal("should fail without the CA")
+	}
+}
+
+func TestDockerRequestTimeout(t *testing.T) {
+	_, done := fakeDocker(t, map[string]http.HandlerFunc{
+		"/slow": func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		},
+	})
+	defer done()
+
+	if err := dockerRequestTimeout("GET", "/slow", nil, nil, 10*time.Millisecond); err == nil {
+		t.Fatal("should time out")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	dockerClient "github.com/fsouza/go-dockerclient"
+)
+
+/* archiveReader reports how much of the archive has been sent to the daemon through STATUS= */
+type archiveReader struct {
+	c          *Context
+	name       string
+	in         io.Reader
+	size       int64
+	read       int64
+	now        func() time.Time
+	lastStatus time.Time
+}
+
+func (r *archiveReader) Read(p []byte) (int, error) {
+	n, err := r.in.Read(p)
+	r.read += int64(n)
+
+	if now := r.now(); r.size > 0 && now.Sub(r.lastStatus) >= STATUS_INTERVAL {
+		r.lastStatus = now
+		sdNotify(r.c, fmt.Sprintf("STATUS=Loading %s: %d%%", r.name, r.read*100/r.size))
+	}
+
+	return n, err
+}
+
+/* loadImage is docker load, the vendored client only has ImportImage which is docker import */
+func loadImage(c *Context, archive string) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	in := &archiveReader{
+		c:    c,
+		name: path.Base(archive),
+		in:   file,
+		size: info.Size(),
+		now:  time.Now,
+	}
+
+	resp, err := dockerDo("POST", "/images/load", in, "application/x-tar", 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	/* Newer daemons stream what was loaded, older ones return nothing */
+	dec := json.NewDecoder(resp.Body)
+	for {
+		m := struct {
+			Stream string `json:"stream"`
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}{}
+
+		if err := dec.Decode(&m); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if len(m.Error) > 0 {
+			return errors.New(m.Error)
+		}
+
+		if line := strings.TrimSpace(m.Stream + m.Status); len(line) > 0 {
+			log.Println(line)
+		}
+	}
+}
+
+/* loadImageArchive loads --image-archive if the image isn't there yet */
+func loadImageArchive(c *Context) error {
+	if len(c.ImageArchive) == 0 || len(c.Image) == 0 {
+		return nil
+	}
+
+	client, err := getClient(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.InspectImage(c.Image)
+	if err == nil {
+		return nil
+	}
+	if err != dockerClient.ErrNoSuchImage {
+		return err
+	}
+
+	log.Println("Loading", c.Image, "from", c.ImageArchive)
+	sdNotify(c, "STATUS=Loading "+path.Base(c.ImageArchive))
+
+	if err := loadImage(c, c.ImageArchive); err != nil {
+		return &exitError{EXIT_IMAGE_UNAVAILABLE, fmt.Errorf("Failed to load %s: %v", c.ImageArchive, err)}
+	}
+
+	_, err = client.InspectImage(c.Image)
+	if err == dockerClient.ErrNoSuchImage {
+		return &exitError{EXIT_IMAGE_UNAVAILABLE, fmt.Errorf("%s does not contain %s", c.ImageArchive, c.Image)}
+	}
+	if err != nil {
+		return err
+	}
+
+	sdNotify(c, "STATUS=Loaded "+c.Image)
+	return nil
+}
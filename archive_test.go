@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+)
+
+func withArchive(t *testing.T, fn func(archive string)) {
+	dir, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := path.Join(dir, "app.tar")
+	if err := ioutil.WriteFile(archive, []byte("not really a tar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fn(archive)
+}
+
+func TestLoadImageArchive(t *testing.T) {
+	withArchive(t, func(archive string) {
+		loaded := false
+		badUpload := ""
+		client, done := fakeDocker(t, map[string]http.HandlerFunc{
+			"/images/app/json": func(w http.ResponseWriter, r *http.Request) {
+				if !loaded {
+					http.Error(w, "No such image: app", http.StatusNotFound)
+					return
+				}
+				w.Write([]byte(`{"Id":"abc"}`))
+			},
+			"/images/load": func(w http.ResponseWriter, r *http.Request) {
+				data, _ := ioutil.ReadAll(r.Body)
+				if string(data) != "not really a tar" || r.Header.Get("Content-Type") != "application/x-tar" {
+					badUpload = string(data)
+				}
+				loaded = true
+				w.Write([]byte(`{"stream":"Loaded image: app:latest\n"}`))
+			},
+		})
+		defer done()
+
+		c := &Context{Client: client, Image: "app", ImageArchive: archive}
+		if err := loadImageArchive(c); err != nil || !loaded || len(badUpload) > 0 {
+			t.Fatal("image should be loaded", err, badUpload)
+		}
+
+		/* Already there, so the archive isn't touched again */
+		loaded = true
+		os.Remove(archive)
+		if err := loadImageArchive(c); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestLoadImageArchiveWrongImage(t *testing.T) {
+	withArchive(t, func(archive string) {
+		client, done := fakeDocker(t, map[string]http.HandlerFunc{
+			"/images/app/json": func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "No such image: app", http.StatusNotFound)
+			},
+			"/images/load": func(w http.ResponseWriter, r *http.Request) {
+				ioutil.ReadAll(r.Body)
+			},
+		})
+		defer done()
+
+		c := &Context{Client: client, Image: "app", ImageArchive: archive}
+		if e, ok := loadImageArchive(c).(*exitError); !ok || e.Code != EXIT_IMAGE_UNAVAILABLE {
+			t.Fatal("should fail when the archive has another image", e)
+		}
+	})
+}
+
+func TestLoadImageArchiveError(t *testing.T) {
+	withArchive(t, func(archive string) {
+		client, done := fakeDocker(t, map[string]http.HandlerFunc{
+			"/images/app/json": func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "No such image: app", http.StatusNotFound)
+			},
+			"/images/load": func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"error":"unexpected EOF"}`))
+			},
+		})
+		defer done()
+
+		c := &Context{Client: client, Image: "app", ImageArchive: archive}
+		if e, ok := loadImageArchive(c).(*exitError); !ok || e.Error() != "Failed to load "+archive+": unexpected EOF" {
+			t.Fatal("load errors should be reported", e)
+		}
+	})
+}
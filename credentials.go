@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const CREDENTIALS_COPY = "systemd-docker-credentials"
+
+func credentialsDirectory() (string, error) {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if len(dir) == 0 {
+		return "", fmt.Errorf("CREDENTIALS_DIRECTORY is not set, use LoadCredential= or SetCredential= in the unit")
+	}
+	return dir, nil
+}
+
+func parseOwner(owner string) (int, int, error) {
+	parts := strings.SplitN(owner, ":", 2)
+	uid, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid credentials owner %s", owner)
+	}
+
+	gid := uid
+	if len(parts) == 2 {
+		gid, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid credentials owner %s", owner)
+		}
+	}
+
+	return uid, gid, nil
+}
+
+/* credentialNames returns the credentials picked with --credential, or all of them */
+func credentialNames(c *Context, dir string) ([]string, error) {
+	if len(c.CredentialNames) > 0 {
+		return c.CredentialNames, nil
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []string{}
+	for _, file := range files {
+		if !file.IsDir() {
+			ret = append(ret, file.Name())
+		}
+	}
+	return ret, nil
+}
+
+/*
+ * copyCredentials copies the credentials into the RuntimeDirectory= owned
+ * by the container user.  The files in CREDENTIALS_DIRECTORY are only
+ * readable by the unit's user, so a non-root container can't read them
+ * through a plain bind mount.  Giving them to another user needs root.
+ */
+func copyCredentials(c *Context, dir string, names []string) (string, error) {
+	uid, gid, err := parseOwner(c.CredentialsOwner)
+	if err != nil {
+		return "", err
+	}
+
+	private, err := privateDir(c)
+	if err != nil {
+		return "", fmt.Errorf("Failed to create a directory for the credential copies, --credentials-owner needs root: %v", err)
+	}
+	if len(private) == 0 {
+		return "", fmt.Errorf("--credentials-owner needs RuntimeDirectory= to be set")
+	}
+
+	target := path.Join(private, CREDENTIALS_COPY)
+	os.RemoveAll(target)
+	if err := os.Mkdir(target, 0700); err != nil {
+		return "", err
+	}
+
+	if err := writeCredentials(target, dir, names, uid, gid); err != nil {
+		os.RemoveAll(target)
+		if os.IsPermission(err) {
+			return "", fmt.Errorf("Failed to give the credentials to %s, --credentials-owner needs root: %v", c.CredentialsOwner, err)
+		}
+		return "", err
+	}
+
+	return target, nil
+}
+
+/* writeCredentials fills target with the credentials, and makes it read only once they are all there */
+func writeCredentials(target string, dir string, names []string, uid int, gid int) error {
+	for _, name := range names {
+		content, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return err
+		}
+
+		file := path.Join(target, name)
+		if err := ioutil.WriteFile(file, content, 0400); err != nil {
+			return err
+		}
+
+		if err := os.Chown(file, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Chown(target, uid, gid); err != nil {
+		return err
+	}
+
+	return os.Chmod(target, 0500)
+}
+
+/*
+ * removeCredentials removes the copies made for --credentials-owner once the
+ * wrapper exits, unless the container is still running detached and needs them.
+ */
+func removeCredentials(c *Context) {
+	if len(c.CredentialsCopy) == 0 {
+		return
+	}
+
+	if c.Pid > 0 && !pidDied(c.Pid) {
+		return
+	}
+
+	if err := os.RemoveAll(c.CredentialsCopy); err != nil {
+		log.Println("Failed to remove the credential copies", err)
+	}
+}
+
+/* setupCredentials mounts the unit's systemd credentials into the container */
+func setupCredentials(c *Context) error {
+	if !c.Credentials {
+		return nil
+	}
+
+	dir, err := credentialsDirectory()
+	if err != nil {
+		return err
+	}
+
+	names, err := credentialNames(c, dir)
+	if err != nil {
+		return err
+	}
+
+	args := []string{}
+
+	switch {
+	case len(c.CredentialsOwner) > 0:
+		target, err := copyCredentials(c, dir, names)
+		if err != nil {
+			return err
+		}
+		c.CredentialsCopy = target
+		args = append(args, "-v", fmt.Sprintf("%s:%s:ro", target, c.CredentialsPath))
+	case len(c.CredentialNames) > 0:
+		for _, name := range names {
+			args = append(args, "-v", fmt.Sprintf("%s:%s:ro", path.Join(dir, name), path.Join(c.CredentialsPath, name)))
+		}
+	default:
+		args = append(args, "-v", fmt.Sprintf("%s:%s:ro", dir, c.CredentialsPath))
+	}
+
+	c.Args = append(args, c.Args...)
+	return nil
+}
+
+/*
+ * credentialEnvironment reads the credentials picked with --credential-env
+ * NAME[=VAR] as KEY=VALUE pairs for the env file.  VAR defaults to NAME in
+ * upper case with anything that isn't allowed in a variable name turned into _.
+ */
+func credentialEnvironment(c *Context) ([]string, error) {
+	if len(c.CredentialEnv) == 0 {
+		return nil, nil
+	}
+
+	dir, err := credentialsDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []string{}
+	for _, val := range c.CredentialEnv {
+		parts := strings.SplitN(val, "=", 2)
+		name, variable := parts[0], envName(parts[0])
+		if len(parts) == 2 {
+			variable = parts[1]
+		}
+
+		content, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		value := strings.TrimRight(string(content), "\n")
+		if strings.Contains(value, "\n") {
+			return nil, fmt.Errorf("credential %s has several lines and can not be passed in an env file, mount it with --credentials instead", name)
+		}
+
+		ret = append(ret, variable+"="+value)
+	}
+
+	return ret, nil
+}
+
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+/* Unlike STATE_DIR this has to survive a reboot, or the pin would be lost */
+var DIGEST_DIR string = "/var/lib/systemd-docker/digests"
+
+/* imageRepository is the full name of the image's repository, like docker.io/library/nginx */
+func imageRepository(image string) string {
+	repository, _ := splitImageName(image)
+	host, name := splitRegistry(strings.SplitN(repository, "@", 2)[0])
+
+	if host == DEFAULT_REGISTRY && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+
+	return host + "/" + name
+}
+
+/* imageDigest finds the registry digest the local image was pulled as, or its id if it was built or loaded */
+func imageDigest(image string) (string, error) {
+	info := struct {
+		Id          string
+		RepoDigests []string
+	}{}
+
+	if err := dockerRequest("GET", "/images/"+image+"/json", nil, &info); err != nil {
+		return "", err
+	}
+
+	repository := imageRepository(image)
+	for _, digest := range info.RepoDigests {
+		parts := strings.SplitN(digest, "@", 2)
+		if len(parts) == 2 && imageRepository(parts[0]) == repository {
+			return parts[1], nil
+		}
+	}
+
+	return info.Id, nil
+}
+
+func digestRecord(c *Context) string {
+	key := containerName(c.Unit)
+	if len(key) == 0 {
+		key = c.Name
+	}
+	if len(key) == 0 {
+		return ""
+	}
+	return path.Join(DIGEST_DIR, key)
+}
+
+/* readDigestRecord returns the image and digest pinned for the unit */
+func readDigestRecord(file string) (string, string, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	parts := strings.Fields(string(bytes))
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("Invalid digest record %s", file)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+func writeDigestRecord(file, image, digest string) error {
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, []byte(image+" "+digest+"\n"), 0644)
+}
+
+/*
+ * pinDigest resolves the image to a digest and compares it with the one
+ * recorded at first start.  Changing the image in ExecStart or passing
+ * --update-digest records the new digest instead.
+ */
+func pinDigest(c *Context) error {
+	if len(c.PinDigest) == 0 || len(c.Image) == 0 {
+		return nil
+	}
+
+	digest, err := imageDigest(c.Image)
+	if e, ok := err.(*apiError); ok && e.Status == 404 {
+		if c.PinDigest == "enforce" {
+			return fmt.Errorf("Image %s is not available locally to check its digest, use --pull=missing", c.Image)
+		}
+		log.Println("Image", c.Image, "is not available locally yet, not recording its digest")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.ImageDigest = digest
+
+	file := digestRecord(c)
+	if len(file) == 0 {
+		log.Println("Not recording the digest of", c.Image, "since the container has no name and there is no unit")
+		return nil
+	}
+
+	image, pinned, err := readDigestRecord(file)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case pinned == digest:
+		return nil
+	case len(pinned) == 0 || image != c.Image:
+		log.Printf("Pinning %s to %s", c.Image, digest)
+	case c.UpdateDigest:
+		log.Printf("Updating %s from %s to %s", c.Image, pinned, digest)
+	case c.PinDigest == "enforce":
+		return fmt.Errorf("Image %s is now %s but was pinned to %s, use --update-digest to accept it", c.Image, digest, pinned)
+	default:
+		log.Printf("Image %s is now %s but was pinned to %s, recording the new digest", c.Image, digest, pinned)
+	}
+
+	return writeDigestRecord(file, c.Image, digest)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestImageRepository(t *testing.T) {
+	for image, expected := range map[string]string{
+		"nginx":                           "docker.io/library/nginx",
+		"nginx:1.9":                       "docker.io/library/nginx",
+		"nginx@sha256:abc":                "docker.io/library/nginx",
+		"docker.io/team/app":              "docker.io/team/app",
+		"index.docker.io/library/nginx":   "docker.io/library/nginx",
+		"localhost:5000/app:v2":           "localhost:5000/app",
+		"registry.example.com/team/a/b":   "registry.example.com/team/a/b",
+		"registry.example.com/app@sha256": "registry.example.com/app",
+	} {
+		if repository := imageRepository(image); repository != expected {
+			t.Fatal("bad repository for", image, repository)
+		}
+	}
+}
+
+func TestPinDigest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := DIGEST_DIR
+	DIGEST_DIR = dir
+	defer func() { DIGEST_DIR = old }()
+
+	digest := "sha256:1111"
+	_, done := fakeDocker(t, map[string]http.HandlerFunc{
+		"/images/nginx/json": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"Id":"abc","RepoDigests":["registry.example.com/nginx@sha256:0000","nginx@` + digest + `"]}`))
+		},
+	})
+	defer done()
+
+	c := &Context{Unit: "web.service", Image: "nginx", PinDigest: "enforce"}
+	if err := pinDigest(c); err != nil || c.ImageDigest != digest {
+		t.Fatal("first start should pin", err, c.ImageDigest)
+	}
+
+	if data, _ := ioutil.ReadFile(path.Join(dir, "web.service")); string(data) != "nginx sha256:1111\n" {
+		t.Fatal("bad record", string(data))
+	}
+
+	digest = "sha256:2222"
+	if err := pinDigest(c); err == nil {
+		t.Fatal("enforce should refuse a new digest")
+	}
+
+	c.PinDigest = "record"
+	if err := pinDigest(c); err != nil {
+		t.Fatal("record should accept a new digest", err)
+	}
+
+	digest = "sha256:3333"
+	c.PinDigest = "enforce"
+	c.UpdateDigest = true
+	if err := pinDigest(c); err != nil {
+		t.Fatal("--update-digest should accept a new digest", err)
+	}
+
+	if _, pinned, _ := readDigestRecord(path.Join(dir, "web.service")); pinned != digest {
+		t.Fatal("digest was not updated", pinned)
+	}
+
+	c.UpdateDigest = false
+	if labels := containerLabels(&Context{Labels: true, ImageDigest: digest}); labels[LABEL_IMAGE_DIGEST] != digest {
+		t.Fatal("digest should be labelled", labels)
+	}
+}
+
+func TestPinDigestMissingImage(t *testing.T) {
+	_, done := fakeDocker(t, map[string]http.HandlerFunc{
+		"/images/nginx/json": func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "No such image: nginx", http.StatusNotFound)
+		},
+	})
+	defer done()
+
+	if err := pinDigest(&Context{Name: "web", Image: "nginx", PinDigest: "record"}); err != nil {
+		t.Fatal("record should wait for the image", err)
+	}
+
+	if err := pinDigest(&Context{Name: "web", Image: "nginx", PinDigest: "enforce"}); err == nil {
+		t.Fatal("enforce can't check a missing image")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"strings"
+
+	dockerClient "github.com/fsouza/go-dockerclient"
+)
+
+/*
+ * configHash identifies the effective run arguments (and the environment
+ * from --env and --credential-env) a container was created with.  It's
+ * stored as a label so a reused named container can be checked against the
+ * current ExecStart.
+ */
+func configHash(c *Context) string {
+	hash := sha256.New()
+	for _, arg := range c.Args {
+		fmt.Fprintf(hash, "%s\x00", arg)
+	}
+
+	if c.Env || len(c.CredentialEnv) > 0 {
+		env, err := containerEnvironment(c)
+		if err != nil {
+			log.Println("Failed to read the environment for the config hash", err)
+		}
+
+		hash.Write([]byte("env\x00"))
+		for _, val := range env {
+			fmt.Fprintf(hash, "%s\x00", val)
+		}
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+/*
+ * driftReasons compares an existing container with what we would create
+ * now.  The image is checked by looking up the image name the container was
+ * created from and comparing its current id with the one the container runs.
+ */
+func driftReasons(c *Context, container *dockerClient.Container) []string {
+	reasons := []string{}
+
+	labels, err := inspectLabels(container.ID)
+	if err != nil {
+		log.Println("Failed to read labels of container", container.ID, err)
+	} else if hash, ok := labels[LABEL_CONFIG_HASH]; ok && hash != configHash(c) {
+		reasons = append(reasons, "run arguments changed")
+	}
+
+	if container.Config == nil || len(container.Config.Image) == 0 {
+		return reasons
+	}
+
+	client, err := getClient(c)
+	if err != nil {
+		return reasons
+	}
+
+	image, err := client.InspectImage(container.Config.Image)
+	if err == nil && image.ID != container.Image {
+		reasons = append(reasons, fmt.Sprintf("image %s changed", container.Config.Image))
+	}
+
+	return reasons
+}
+
+/* checkDrift returns true if the container should be thrown away and recreated */
+func checkDrift(c *Context, container *dockerClient.Container) (bool, error) {
+	reasons := driftReasons(c, container)
+	if len(reasons) == 0 {
+		return false, nil
+	}
+
+	message := fmt.Sprintf("Container %s is out of date: %s", c.Name, strings.Join(reasons, ", "))
+
+	switch c.OnDrift {
+	case "recreate":
+		log.Println(message + ", recreating")
+		return true, nil
+	case "refuse":
+		return false, fmt.Errorf("%s, refusing to start (see --on-drift)", message)
+	default:
+		log.Println(message + ", using it anyway")
+		return false, nil
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	dockerClient "github.com/fsouza/go-dockerclient"
+)
+
+/* fakeDocker serves just the API calls a test needs, DOCKER_HOST points at it until done is called */
+func fakeDocker(t *testing.T, handlers map[string]http.HandlerFunc) (*dockerClient.Client, func()) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ApiVersion":"1.12"}`))
+	})
+	for path, handler := range handlers {
+		mux.HandleFunc(path, handler)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/v1.12")
+		mux.ServeHTTP(w, r)
+	}))
+	client, err := dockerClient.NewVersionedClient(server.URL, "1.12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host, set := os.LookupEnv("DOCKER_HOST")
+	os.Setenv("DOCKER_HOST", server.URL)
+
+	return client, func() {
+		if set {
+			os.Setenv("DOCKER_HOST", host)
+		} else {
+			os.Unsetenv("DOCKER_HOST")
+		}
+		server.Close()
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	a := configHash(&Context{Args: []string{"-d", "-p", "80:80", "nginx"}})
+	b := configHash(&Context{Args: []string{"-d", "-p", "80:80", "nginx"}})
+	c := configHash(&Context{Args: []string{"-d", "-p", "8080:80", "nginx"}})
+	d := configHash(&Context{Args: []string{"-d", "-p80:80", "nginx"}})
+
+	if a != b {
+		t.Fatal("same args should hash the same")
+	}
+
+	if a == c || a == d {
+		t.Fatal("different args should hash differently")
+	}
+}
+
+func TestConfigHashCredentials(t *testing.T) {
+	withCredentials(t, func(dir string) {
+		c := &Context{Args: []string{"nginx"}, CredentialEnv: []string{"db-password"}}
+		before := configHash(c)
+
+		ioutil.WriteFile(path.Join(dir, "db-password"), []byte("changed\n"), 0400)
+		if configHash(c) == before {
+			t.Fatal("a changed credential should change the hash")
+		}
+	})
+}
+
+func TestDriftPolicy(t *testing.T) {
+	hash := ""
+	client, done := fakeDocker(t, map[string]http.HandlerFunc{
+		"/images/nginx/json": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"Id":"new"}`))
+		},
+		"/containers/abc/json": func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"Id":     "abc",
+				"Config": map[string]interface{}{"Labels": map[string]string{LABEL_CONFIG_HASH: hash}},
+			})
+		},
+	})
+	defer done()
+
+	container := &dockerClient.Container{
+		ID:     "abc",
+		Image:  "old",
+		Config: &dockerClient.Config{Image: "nginx"},
+	}
+
+	c := &Context{Name: "test", Args: []string{"nginx"}, Client: client, OnDrift: "ignore"}
+	hash = configHash(c)
+
+	if recreate, err := checkDrift(c, container); recreate || err != nil {
+		t.Fatal("ignore should keep the container", recreate, err)
+	}
+
+	c.OnDrift = "recreate"
+	if recreate, err := checkDrift(c, container); !recreate || err != nil {
+		t.Fatal("recreate should replace the container", recreate, err)
+	}
+
+	c.OnDrift = "refuse"
+	if _, err := checkDrift(c, container); err == nil {
+		t.Fatal("refuse should fail")
+	}
+
+	container.Image = "new"
+	if recreate, err := checkDrift(c, container); recreate || err != nil {
+		t.Fatal("container is up to date", recreate, err)
+	}
+
+	/* Same image, different run arguments */
+	if reasons := driftReasons(c, container); len(reasons) != 0 {
+		t.Fatal("hash matches", reasons)
+	}
+
+	hash = configHash(&Context{Args: []string{"-p", "80:80", "nginx"}})
+	if reasons := driftReasons(c, container); len(reasons) != 1 || reasons[0] != "run arguments changed" {
+		t.Fatal("hash should not match", reasons)
+	}
+
+	c.OnDrift = "recreate"
+	if recreate, err := checkDrift(c, container); !recreate || err != nil {
+		t.Fatal("changed arguments should replace the container", recreate, err)
+	}
+}
+
+func TestParseOnDrift(t *testing.T) {
+	c, err := parseContext([]string{"--on-drift=recreate", "run", "nginx"})
+	if err != nil || c.OnDrift != "recreate" {
+		t.Fatal("failed to parse:", err)
+	}
+
+	_, err = parseContext([]string{"--on-drift=bad", "run", "nginx"})
+	if err == nil {
+		t.Fatal("parse should fail on a bad drift policy")
+	}
+}
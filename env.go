@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+/* Variables set by systemd (or for the host user) that make no sense inside a container */
+var ENV_DENYLIST = []string{
+	"HOME",
+	"PATH",
+	"USER",
+	"LOGNAME",
+	"SHELL",
+	"NOTIFY_SOCKET",
+	"INVOCATION_ID",
+	"JOURNAL_STREAM",
+	"LISTEN_FDS",
+	"LISTEN_PID",
+	"LISTEN_FDNAMES",
+	"WATCHDOG_PID",
+	"WATCHDOG_USEC",
+	"MAINPID",
+	"MANAGERPID",
+	"SYSTEMD_EXEC_PID",
+	"PIDFILE",
+	"RUNTIME_DIRECTORY",
+	"STATE_DIRECTORY",
+	"CACHE_DIRECTORY",
+	"LOGS_DIRECTORY",
+	"CONFIGURATION_DIRECTORY",
+	"CREDENTIALS_DIRECTORY",
+	"EXIT_CODE",
+	"EXIT_STATUS",
+	"SERVICE_RESULT",
+	"MONITOR_*",
+	"TRIGGER_*",
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * inheritedEnvironment returns the KEY=VALUE pairs --env should pass to
+ * the container.  Variables on the denylist are never passed.  With
+ * --env-prefix only variables starting with the prefix are passed (and
+ * renamed if --env-strip-prefix is set).  --env-include and --env-exclude
+ * then narrow it down.
+ */
+func inheritedEnvironment(c *Context) []string {
+	ret := []string{}
+
+	for _, val := range os.Environ() {
+		parts := strings.SplitN(val, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		name := parts[0]
+
+		if matchAny(ENV_DENYLIST, name) {
+			continue
+		}
+
+		if len(c.EnvPrefix) > 0 && !strings.HasPrefix(name, c.EnvPrefix) {
+			continue
+		}
+
+		if len(c.EnvInclude) > 0 && !matchAny(c.EnvInclude, name) {
+			continue
+		}
+
+		if matchAny(c.EnvExclude, name) {
+			continue
+		}
+
+		if len(c.EnvPrefix) > 0 && c.EnvStripPrefix {
+			name = strings.TrimPrefix(name, c.EnvPrefix)
+			if len(name) == 0 || matchAny(ENV_DENYLIST, name) {
+				continue
+			}
+		}
+
+		ret = append(ret, name+"="+parts[1])
+	}
+
+	return ret
+}
+
+/* containerEnvironment is everything that goes in the private env file */
+func containerEnvironment(c *Context) ([]string, error) {
+	env := []string{}
+	if c.Env {
+		env = inheritedEnvironment(c)
+	}
+
+	credentials, err := credentialEnvironment(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(env, credentials...), nil
+}
+
+/*
+ * writeEnvFile writes the inherited environment to a private file for
+ * --env-file so secrets don't end up on the docker command line where
+ * anyone can see them with ps.  The file goes in the wrapper's private
+ * directory if it has one, the system temp directory otherwise.  The caller
+ * removes it.
+ */
+func writeEnvFile(c *Context) (string, error) {
+	dir, err := privateDir(c)
+	if err != nil {
+		dir = ""
+	}
+
+	/* TempFile creates the file 0600 */
+	file, err := ioutil.TempFile(dir, "systemd-docker-env-")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	env, err := containerEnvironment(c)
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	content := []byte{}
+	for _, val := range env {
+		/* Only inherited ones, credentialEnvironment refuses these */
+		if strings.Contains(val, "\n") {
+			log.Println("Not inheriting multi-line variable", strings.SplitN(val, "=", 2)[0])
+			continue
+		}
+		content = append(content, val+"\n"...)
+	}
+
+	if _, err := file.Write(content); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
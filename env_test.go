@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func envContains(env []string, val string) bool {
+	for _, e := range env {
+		if e == val {
+			return true
+		}
+	}
+	return false
+}
+
+func TestInheritDenylist(t *testing.T) {
+	os.Setenv("INVOCATION_ID", "abc")
+	os.Setenv("SD_TEST_VAR", "1")
+	defer os.Unsetenv("INVOCATION_ID")
+	defer os.Unsetenv("SD_TEST_VAR")
+
+	env := inheritedEnvironment(&Context{})
+	if envContains(env, "INVOCATION_ID=abc") {
+		t.Fatal("INVOCATION_ID should not be inherited")
+	}
+	if !envContains(env, "SD_TEST_VAR=1") {
+		t.Fatal("SD_TEST_VAR should be inherited")
+	}
+}
+
+func TestInheritIncludeExclude(t *testing.T) {
+	os.Setenv("SD_TEST_A", "1")
+	os.Setenv("SD_TEST_B", "2")
+	os.Setenv("SD_OTHER", "3")
+	defer os.Unsetenv("SD_TEST_A")
+	defer os.Unsetenv("SD_TEST_B")
+	defer os.Unsetenv("SD_OTHER")
+
+	env := inheritedEnvironment(&Context{
+		EnvInclude: []string{"SD_TEST_*"},
+		EnvExclude: []string{"*_B"},
+	})
+
+	if len(env) != 1 || env[0] != "SD_TEST_A=1" {
+		t.Fatal("bad environment", env)
+	}
+}
+
+func TestInheritPrefix(t *testing.T) {
+	os.Setenv("APP_DB", "x")
+	os.Setenv("APP_", "empty")
+	os.Setenv("OTHER_DB", "y")
+	defer os.Unsetenv("APP_DB")
+	defer os.Unsetenv("APP_")
+	defer os.Unsetenv("OTHER_DB")
+
+	env := inheritedEnvironment(&Context{EnvPrefix: "APP_"})
+	if len(env) != 2 || !envContains(env, "APP_DB=x") {
+		t.Fatal("bad environment", env)
+	}
+
+	env = inheritedEnvironment(&Context{EnvPrefix: "APP_", EnvStripPrefix: true})
+	if len(env) != 1 || env[0] != "DB=x" {
+		t.Fatal("bad environment", env)
+	}
+}
+
+func TestParseEnvPrefix(t *testing.T) {
+	c, err := parseContext([]string{"--env-prefix=APP_", "run"})
+	if err != nil {
+		t.Fatal("failed to parse:", err)
+	}
+
+	if !c.Env {
+		t.Fatal("env should be set by --env-prefix")
+	}
+}
+
+func TestWriteEnvFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("RUNTIME_DIRECTORY", dir)
+	os.Setenv("APP_PASSWORD", "hunter2")
+	os.Setenv("APP_MULTI", "a\nb")
+	defer os.Unsetenv("RUNTIME_DIRECTORY")
+	defer os.Unsetenv("APP_PASSWORD")
+	defer os.Unsetenv("APP_MULTI")
+
+	envFile, err := writeEnvFile(&Context{Env: true, EnvPrefix: "APP_"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path.Dir(envFile) != dir {
+		t.Fatal("env file should be in RUNTIME_DIRECTORY", envFile)
+	}
+
+	info, err := os.Stat(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatal("env file should be private", info.Mode())
+	}
+
+	content, err := ioutil.ReadFile(envFile)
+	if err != nil || string(content) != "APP_PASSWORD=hunter2\n" {
+		t.Fatal("bad env file", string(content), err)
+	}
+}
+
+func TestParseEnvNoArgs(t *testing.T) {
+	os.Setenv("APP_PASSWORD", "hunter2")
+	defer os.Unsetenv("APP_PASSWORD")
+
+	c, err := parseContext([]string{"--env", "run", "nginx"})
+	if err != nil {
+		t.Fatal("failed to parse:", err)
+	}
+
+	for _, arg := range c.Args {
+		if arg == "APP_PASSWORD=hunter2" {
+			t.Fatal("environment should not be passed as args", c.Args)
+		}
+	}
+}
+
+func TestInheritPrefixDenylist(t *testing.T) {
+	os.Setenv("LISTEN_FDS", "2")
+	os.Setenv("LISTEN_PID", "1")
+	os.Setenv("APP_NOTIFY_SOCKET", "/run/x")
+	os.Setenv("APP_DB", "x")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("APP_NOTIFY_SOCKET")
+	defer os.Unsetenv("APP_DB")
+
+	if env := inheritedEnvironment(&Context{EnvPrefix: "LISTEN_"}); len(env) != 0 {
+		t.Fatal("systemd's variables should not be passed", env)
+	}
+
+	env := inheritedEnvironment(&Context{EnvPrefix: "APP_", EnvStripPrefix: true})
+	if len(env) != 1 || env[0] != "DB=x" {
+		t.Fatal("stripped names should be checked too", env)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+/* Directories systemd creates for the unit and hands to the wrapper, owned by User= */
+var WRITABLE_UNIT_DIRS = []string{"STATE_DIRECTORY", "CACHE_DIRECTORY"}
+
+/* identityArgs runs the container as the uid, gid and groups systemd started us with */
+func identityArgs(uid int, gid int, groups []int) []string {
+	args := []string{"--user", fmt.Sprintf("%d:%d", uid, gid)}
+	for _, group := range groups {
+		if group != gid {
+			args = append(args, "--group-add", strconv.Itoa(group))
+		}
+	}
+	return args
+}
+
+/* unitDirs returns the paths of the unit's directories from their environment variables */
+func unitDirs(names []string) []string {
+	ret := []string{}
+	for _, name := range names {
+		for _, dir := range strings.Split(os.Getenv(name), ":") {
+			if len(dir) > 0 {
+				ret = append(ret, path.Clean(dir))
+			}
+		}
+	}
+	return ret
+}
+
+func underDir(file string, dir string) bool {
+	return file == dir || strings.HasPrefix(file, dir+"/")
+}
+
+/* writableBy checks the mode bits the way the kernel would for uid, gid and groups */
+func writableBy(info os.FileInfo, uid int, gid int, groups []int) bool {
+	if uid == 0 {
+		return true
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+
+	mode := info.Mode().Perm()
+	if int(stat.Uid) == uid {
+		return mode&0200 != 0
+	}
+
+	inGroup := int(stat.Gid) == gid
+	for _, group := range groups {
+		inGroup = inGroup || int(stat.Gid) == group
+	}
+	if inGroup {
+		return mode&0020 != 0
+	}
+
+	return mode&0002 != 0
+}
+
+/*
+ * checkUnitDirs makes sure bind mounts of StateDirectory= and CacheDirectory=
+ * can be written by the identity the container runs as.  The wrapper may
+ * be root, so this looks at the owner and mode instead of asking access().
+ */
+func checkUnitDirs(mounts []bindMount, uid int, gid int, groups []int) error {
+	dirs := unitDirs(WRITABLE_UNIT_DIRS)
+
+	for _, mount := range mounts {
+		if mount.ReadOnly {
+			continue
+		}
+
+		for _, dir := range dirs {
+			if !underDir(mount.Source, dir) {
+				continue
+			}
+
+			info, err := os.Stat(mount.Source)
+			if err == nil && !writableBy(info, uid, gid, groups) {
+				err = errors.New("permission denied")
+			}
+			if err != nil {
+				return fmt.Errorf("%s is not writable by uid %d gid %d: %v", mount.Source, uid, gid, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+/* setupIdentity returns the arguments that make the container run as the unit's User= */
+func setupIdentity(c *Context, run *runArgs) ([]string, error) {
+	if !c.UnitUser {
+		return nil, nil
+	}
+
+	if _, ok := run.Get("user"); ok {
+		return nil, errors.New("--unit-user can't be used with docker run --user")
+	}
+
+	groups, err := os.Getgroups()
+	if err != nil {
+		return nil, err
+	}
+
+	/* Including what --mount-unit-dirs is going to add */
+	mounts := run.BindMounts()
+	if c.MountUnitDirs {
+		mounts = append(mounts, parseRunArgs(unitDirArgs(nil)).BindMounts()...)
+	}
+
+	if err := checkUnitDirs(mounts, os.Getuid(), os.Getgid(), groups); err != nil {
+		return nil, err
+	}
+
+	return identityArgs(os.Getuid(), os.Getgid(), groups), nil
+}
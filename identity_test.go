@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestIdentityArgs(t *testing.T) {
+	args := identityArgs(1000, 1000, []int{1000, 27, 998})
+	expected := []string{"--user", "1000:1000", "--group-add", "27", "--group-add", "998"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatal("bad identity args", args)
+	}
+}
+
+func TestCheckUnitDirs(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing owners needs root")
+	}
+
+	dir, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	owned := path.Join(dir, "owned")
+	group := path.Join(dir, "group")
+	other := path.Join(dir, "other")
+	for _, sub := range []string{owned, group, other} {
+		os.Mkdir(sub, 0755)
+	}
+	os.Chmod(group, 0775)
+	os.Chown(owned, 1000, 1000)
+	os.Chown(group, 0, 27)
+	os.Chown(other, 0, 0)
+
+	old := os.Getenv("STATE_DIRECTORY")
+	defer os.Setenv("STATE_DIRECTORY", old)
+	os.Setenv("STATE_DIRECTORY", dir+":"+path.Join(dir, "missing"))
+
+	for _, test := range []struct {
+		args []string
+		ok   bool
+	}{
+		{[]string{"-v", owned + ":/var/lib/app"}, true},
+		{[]string{"-v", owned + "/:/var/lib/app:rw"}, true},
+		{[]string{"-v", group + ":/var/lib/app"}, true},
+		{[]string{"-v", other + ":/var/lib/app"}, false},
+		{[]string{"--mount", "type=bind,source=" + other + ",target=/data"}, false},
+		{[]string{"-v", other + ":/var/lib/app:ro"}, true},
+		{[]string{"-v", path.Join(dir, "missing") + ":/var/lib/other"}, false},
+		{[]string{"-v", path.Join(dir, "missing") + ":/var/lib/other:ro"}, true},
+		{[]string{"-v", "/nonexistent:/data"}, true},
+		{[]string{"-v", "volume:/data"}, true},
+	} {
+		mounts := parseRunArgs(append(test.args, "nginx")).BindMounts()
+		err := checkUnitDirs(mounts, 1000, 1000, []int{1000, 27})
+		if test.ok != (err == nil) {
+			t.Fatal("wrong result for", test.args, err)
+		}
+	}
+
+	/* root can write anywhere */
+	mounts := parseRunArgs([]string{"-v", other + ":/data", "nginx"}).BindMounts()
+	if err := checkUnitDirs(mounts, 0, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseUnitUser(t *testing.T) {
+	c, err := parseContext([]string{"--unit-user", "--auto-name=false", "run", "nginx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())}
+	if !reflect.DeepEqual(c.Args[:2], expected) || c.Args[len(c.Args)-1] != "nginx" {
+		t.Fatal("bad args", c.Args)
+	}
+
+	if _, err := parseContext([]string{"--unit-user", "run", "-u", "app", "nginx"}); err == nil {
+		t.Fatal("--unit-user should conflict with --user")
+	}
+}
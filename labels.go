@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+var (
+	VERSION    string = "dev"
+	MACHINE_ID string = "/etc/machine-id"
+)
+
+const (
+	LABEL_UNIT          = "io.systemd-docker.unit"
+	LABEL_INVOCATION_ID = "io.systemd-docker.invocation-id"
+	LABEL_MACHINE_ID    = "io.systemd-docker.machine-id"
+	LABEL_VERSION       = "io.systemd-docker.version"
+	LABEL_ARGS          = "io.systemd-docker.args"
+	LABEL_CONFIG_HASH   = "io.systemd-docker.config-hash"
+	LABEL_IMAGE_DIGEST  = "io.systemd-docker.image-digest"
+)
+
+/* Environment variables the container gets with the same values as the labels */
+var LABEL_ENV = map[string]string{
+	LABEL_UNIT:          "SYSTEMD_DOCKER_UNIT",
+	LABEL_INVOCATION_ID: "SYSTEMD_DOCKER_INVOCATION_ID",
+	LABEL_MACHINE_ID:    "SYSTEMD_DOCKER_MACHINE_ID",
+	LABEL_VERSION:       "SYSTEMD_DOCKER_VERSION",
+	LABEL_ARGS:          "SYSTEMD_DOCKER_ARGS",
+	LABEL_IMAGE_DIGEST:  "SYSTEMD_DOCKER_IMAGE_DIGEST",
+}
+
+func getMachineId() string {
+	bytes, err := ioutil.ReadFile(MACHINE_ID)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(bytes))
+}
+
+/* ownerLabels describes who started the container, args are redacted since they end up in docker inspect */
+func ownerLabels(c *Context, args []string) map[string]string {
+	labels := map[string]string{
+		LABEL_UNIT:          c.Unit,
+		LABEL_INVOCATION_ID: os.Getenv("INVOCATION_ID"),
+		LABEL_MACHINE_ID:    c.MachineId,
+		LABEL_VERSION:       VERSION,
+		LABEL_ARGS:          c.Redactor.Redact(strings.Join(args, " ")),
+	}
+
+	for key, value := range labels {
+		if len(value) == 0 {
+			delete(labels, key)
+		}
+	}
+
+	return labels
+}
+
+func setupLabels(c *Context, args []string) {
+	if c.Labels {
+		c.OwnerLabels = ownerLabels(c, args)
+	}
+}
+
+/* containerLabels are the labels to create a container with */
+func containerLabels(c *Context) map[string]string {
+	if !c.Labels {
+		return nil
+	}
+
+	labels := map[string]string{
+		LABEL_CONFIG_HASH: configHash(c),
+	}
+	for key, value := range c.OwnerLabels {
+		labels[key] = value
+	}
+	if len(c.ImageDigest) > 0 {
+		labels[LABEL_IMAGE_DIGEST] = c.ImageDigest
+	}
+
+	return labels
+}
+
+/* labelEnvironment gives the container the owner labels as environment variables */
+func labelEnvironment(labels map[string]string) []string {
+	keys := []string{}
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	env := []string{}
+	for _, key := range keys {
+		if name, ok := LABEL_ENV[key]; ok {
+			env = append(env, fmt.Sprintf("%s=%s", name, labels[key]))
+		}
+	}
+
+	return env
+}
+
+/* labelArgs are added to docker run so the labels are only applied to containers we create */
+func labelArgs(c *Context) []string {
+	labels := containerLabels(c)
+
+	keys := []string{}
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	args := []string{}
+	for _, key := range keys {
+		args = append(args, "--label", fmt.Sprintf("%s=%s", key, labels[key]))
+	}
+
+	for _, env := range labelEnvironment(labels) {
+		args = append(args, "-e", env)
+	}
+
+	return args
+}
+
+/* inspectLabels gets the labels of a container, the docker client we use is too old to know about them */
+func inspectLabels(id string) (map[string]string, error) {
+	details, err := inspectDetails(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if details.Config.Labels == nil {
+		return map[string]string{}, nil
+	}
+	return details.Config.Labels, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestOwnerLabels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := MACHINE_ID
+	MACHINE_ID = path.Join(dir, "machine-id")
+	defer func() { MACHINE_ID = old }()
+	ioutil.WriteFile(MACHINE_ID, []byte("abc123\n"), 0444)
+
+	os.Setenv("INVOCATION_ID", "inv1")
+	defer os.Unsetenv("INVOCATION_ID")
+
+	withCgroupFile(t, "0::/system.slice/nginx.service\n", func() {
+		c, err := parseContext([]string{"run", "-e", "DB_PASSWORD=x", "nginx"})
+		if err != nil {
+			t.Fatal("failed to parse:", err)
+		}
+
+		expected := map[string]string{
+			LABEL_UNIT:          "nginx.service",
+			LABEL_INVOCATION_ID: "inv1",
+			LABEL_MACHINE_ID:    "abc123",
+			LABEL_VERSION:       VERSION,
+			LABEL_ARGS:          "run -e DB_PASSWORD=" + REDACTED + " nginx",
+		}
+		if !reflect.DeepEqual(c.OwnerLabels, expected) {
+			t.Fatal("bad labels", c.OwnerLabels)
+		}
+
+		args := labelArgs(c)
+		if args[0] != "--label" || args[1] != LABEL_ARGS+"="+expected[LABEL_ARGS] {
+			t.Fatal("bad label args", args)
+		}
+
+		env := labelEnvironment(c.OwnerLabels)
+		if env[0] != "SYSTEMD_DOCKER_ARGS="+expected[LABEL_ARGS] || len(env) != 5 {
+			t.Fatal("bad label environment", env)
+		}
+	})
+}
+
+func TestOwnerLabelsDisabled(t *testing.T) {
+	c, err := parseContext([]string{"--labels=false", "run", "nginx"})
+	if err != nil {
+		t.Fatal("failed to parse:", err)
+	}
+
+	if len(labelArgs(c)) != 0 {
+		t.Fatal("labels should be disabled", labelArgs(c))
+	}
+}
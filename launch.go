@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/pkg/units"
+	dockerClient "github.com/fsouza/go-dockerclient"
+)
+
+/* fallbackError means the API can't do what was asked and docker run should be used instead */
+type fallbackError struct {
+	Reason string
+}
+
+func (e *fallbackError) Error() string {
+	return e.Reason
+}
+
+/* createConfig is the body of a create call, the vendored Config is too old to have Labels */
+type createConfig struct {
+	dockerClient.Config
+	Labels     map[string]string `json:",omitempty"`
+	HostConfig *createHostConfig `json:",omitempty"`
+}
+
+/* createHostConfig adds what the vendored HostConfig is too old to have */
+type createHostConfig struct {
+	dockerClient.HostConfig
+	GroupAdd  []string   `json:",omitempty"`
+	LogConfig *logConfig `json:",omitempty"`
+}
+
+type logConfig struct {
+	Type   string
+	Config map[string]string `json:",omitempty"`
+}
+
+func flagBool(f runFlag) bool {
+	return !f.HasValue || f.Value != "false"
+}
+
+/* envValue handles -e NAME, which docker run takes from its own environment */
+func envValue(val string) (string, bool) {
+	if strings.Contains(val, "=") {
+		return val, true
+	}
+
+	if value, ok := os.LookupEnv(val); ok {
+		return val + "=" + value, true
+	}
+
+	return "", false
+}
+
+func readEnvFile(name string) ([]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ret := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimLeft(scanner.Text(), " \t")
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if val, ok := envValue(line); ok {
+			ret = append(ret, val)
+		}
+	}
+
+	return ret, scanner.Err()
+}
+
+/* parsePublish handles -p [[ip:]hostPort:]containerPort[/proto] */
+func parsePublish(spec string) (dockerClient.Port, dockerClient.PortBinding, error) {
+	binding := dockerClient.PortBinding{}
+
+	proto := "tcp"
+	if i := strings.LastIndex(spec, "/"); i >= 0 {
+		proto = spec[i+1:]
+		spec = spec[:i]
+	}
+
+	parts := strings.Split(spec, ":")
+	switch len(parts) {
+	case 1:
+	case 2:
+		binding.HostPort = parts[0]
+	case 3:
+		binding.HostIp = parts[0]
+		binding.HostPort = parts[1]
+	default:
+		return "", binding, &fallbackError{"unsupported port mapping " + spec}
+	}
+
+	containerPort := parts[len(parts)-1]
+	if _, err := strconv.ParseUint(containerPort, 10, 16); err != nil {
+		return "", binding, &fallbackError{"unsupported port mapping " + spec}
+	}
+
+	return dockerClient.Port(containerPort + "/" + proto), binding, nil
+}
+
+func parseRestart(policy string) (dockerClient.RestartPolicy, error) {
+	parts := strings.SplitN(policy, ":", 2)
+	restart := dockerClient.RestartPolicy{Name: parts[0]}
+
+	if len(parts) == 2 {
+		retry, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return restart, fmt.Errorf("invalid restart policy %s", policy)
+		}
+		restart.MaxRetry = retry
+	}
+
+	return restart, nil
+}
+
+/*
+ * runConfig turns docker run arguments into a create call.  Anything it
+ * doesn't know how to translate returns a fallbackError.
+ */
+func runConfig(c *Context, args []string) (*createConfig, string, error) {
+	run := parseRunArgs(args)
+	if len(run.Image) == 0 {
+		return nil, "", &fallbackError{"no image given"}
+	}
+
+	config := &createConfig{
+		Config: dockerClient.Config{
+			Image: run.Image,
+			Cmd:   run.Command,
+		},
+		HostConfig: &createHostConfig{},
+		Labels:     map[string]string{},
+	}
+	hostConfig := config.HostConfig
+	name := ""
+
+	for key, value := range containerLabels(c) {
+		config.Labels[key] = value
+	}
+
+	env, err := containerEnvironment(c)
+	if err != nil {
+		return nil, "", err
+	}
+	config.Env = append(labelEnvironment(config.Labels), env...)
+
+	for _, f := range run.Flags {
+		var err error
+
+		if RUN_VALUE_FLAGS[f.Name] && !f.HasValue {
+			return nil, "", &fallbackError{"missing value for " + f.Args[0]}
+		}
+
+		switch f.Name {
+		case "detach":
+		case "name":
+			name = f.Value
+		case "env":
+			if val, ok := envValue(f.Value); ok {
+				config.Env = append(config.Env, val)
+			}
+		case "env-file":
+			vals, err := readEnvFile(f.Value)
+			if err != nil {
+				return nil, "", err
+			}
+			config.Env = append(config.Env, vals...)
+		case "label":
+			parts := strings.SplitN(f.Value, "=", 2)
+			config.Labels[parts[0]] = ""
+			if len(parts) == 2 {
+				config.Labels[parts[0]] = parts[1]
+			}
+		case "hostname":
+			config.Hostname = f.Value
+		case "domainname":
+			config.Domainname = f.Value
+		case "user":
+			config.User = f.Value
+		case "workdir":
+			config.WorkingDir = f.Value
+		case "entrypoint":
+			/* An empty list clears the image's entrypoint, null would keep it */
+			config.Entrypoint = []string{}
+			if len(f.Value) > 0 {
+				config.Entrypoint = []string{f.Value}
+			}
+		case "memory":
+			config.Memory, err = units.RAMInBytes(f.Value)
+		case "memory-swap":
+			if f.Value == "-1" {
+				config.MemorySwap = -1
+			} else {
+				config.MemorySwap, err = units.RAMInBytes(f.Value)
+			}
+		case "cpu-shares":
+			config.CpuShares, err = strconv.ParseInt(f.Value, 10, 64)
+		case "tty":
+			config.Tty = flagBool(f)
+		case "interactive":
+			config.OpenStdin = flagBool(f)
+		case "volume":
+			if strings.Contains(f.Value, ":") {
+				hostConfig.Binds = append(hostConfig.Binds, f.Value)
+			} else {
+				if config.Volumes == nil {
+					config.Volumes = map[string]struct{}{}
+				}
+				config.Volumes[f.Value] = struct{}{}
+			}
+		case "publish", "expose":
+			port, binding, err := parsePublish(f.Value)
+			if err != nil {
+				return nil, "", err
+			}
+			if config.ExposedPorts == nil {
+				config.ExposedPorts = map[dockerClient.Port]struct{}{}
+			}
+			config.ExposedPorts[port] = struct{}{}
+			if f.Name == "publish" {
+				if hostConfig.PortBindings == nil {
+					hostConfig.PortBindings = map[dockerClient.Port][]dockerClient.PortBinding{}
+				}
+				hostConfig.PortBindings[port] = append(hostConfig.PortBindings[port], binding)
+			}
+		case "publish-all":
+			hostConfig.PublishAllPorts = flagBool(f)
+		case "privileged":
+			hostConfig.Privileged = flagBool(f)
+		case "link":
+			hostConfig.Links = append(hostConfig.Links, f.Value)
+		case "dns":
+			hostConfig.Dns = append(hostConfig.Dns, f.Value)
+		case "dns-search":
+			hostConfig.DnsSearch = append(hostConfig.DnsSearch, f.Value)
+		case "volumes-from":
+			hostConfig.VolumesFrom = append(hostConfig.VolumesFrom, f.Value)
+		case "network":
+			hostConfig.NetworkMode = f.Value
+		case "restart":
+			hostConfig.RestartPolicy, err = parseRestart(f.Value)
+		case "group-add":
+			hostConfig.GroupAdd = append(hostConfig.GroupAdd, f.Value)
+		case "log-driver":
+			if hostConfig.LogConfig == nil {
+				hostConfig.LogConfig = &logConfig{}
+			}
+			hostConfig.LogConfig.Type = f.Value
+		case "log-opt":
+			if hostConfig.LogConfig == nil {
+				hostConfig.LogConfig = &logConfig{}
+			}
+			if hostConfig.LogConfig.Config == nil {
+				hostConfig.LogConfig.Config = map[string]string{}
+			}
+			parts := strings.SplitN(f.Value, "=", 2)
+			if len(parts) != 2 {
+				return nil, "", fmt.Errorf("invalid log option %s", f.Value)
+			}
+			hostConfig.LogConfig.Config[parts[0]] = parts[1]
+		default:
+			return nil, "", &fallbackError{"unsupported flag " + f.Args[0]}
+		}
+
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return config, name, nil
+}
+
+/* apiLaunchContainer creates and starts the container through the API instead of docker run */
+func apiLaunchContainer(c *Context) error {
+	config, name, err := runConfig(c, c.Args)
+	if err != nil {
+		return err
+	}
+
+	path := "/containers/create"
+	if len(name) > 0 {
+		path += "?name=" + url.QueryEscape(name)
+	}
+
+	created := struct {
+		Id string
+	}{}
+
+	err = dockerRequest("POST", path, config, &created)
+	if e, ok := err.(*apiError); ok && e.Status == 404 {
+		return &fallbackError{fmt.Sprintf("image %s is not available locally", config.Image)}
+	}
+	if err != nil {
+		return err
+	}
+
+	c.Id = created.Id
+
+	/* Old daemons replace the host config on start, so send all of it again */
+	err = dockerRequest("POST", "/containers/"+c.Id+"/start", config.HostConfig, nil)
+	if err != nil {
+		return err
+	}
+
+	c.Pid, err = getContainerPid(c)
+	return err
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	dockerClient "github.com/fsouza/go-dockerclient"
+)
+
+func TestRunConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	envFile := path.Join(dir, "env")
+	ioutil.WriteFile(envFile, []byte("# comment\nA=1\n\nB=2\n"), 0644)
+
+	os.Setenv("SYSTEMD_DOCKER_TEST_ENV", "inherited")
+	defer os.Unsetenv("SYSTEMD_DOCKER_TEST_ENV")
+
+	c := &Context{}
+	config, name, err := runConfig(c, []string{
+		"-d", "--name", "web", "-e", "X=1", "-e", "SYSTEMD_DOCKER_TEST_ENV", "--env-file", envFile,
+		"-l", "a=b", "-p", "8080:80", "-p", "127.0.0.1::53/udp", "--expose", "9000",
+		"-v", "/data", "-v", "/srv:/srv:ro", "-m", "512m", "--restart=on-failure:3",
+		"--net", "host", "-it", "nginx", "nginx", "-g", "daemon off;",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name != "web" {
+		t.Fatal("bad name", name)
+	}
+
+	if config.Image != "nginx" || !reflect.DeepEqual(config.Cmd, []string{"nginx", "-g", "daemon off;"}) {
+		t.Fatal("bad image or command", config.Image, config.Cmd)
+	}
+
+	if !reflect.DeepEqual(config.Env, []string{"X=1", "SYSTEMD_DOCKER_TEST_ENV=inherited", "A=1", "B=2"}) {
+		t.Fatal("bad env", config.Env)
+	}
+
+	if !reflect.DeepEqual(config.Labels, map[string]string{"a": "b"}) {
+		t.Fatal("bad labels", config.Labels)
+	}
+
+	if config.Memory != 512*1024*1024 || !config.Tty || !config.OpenStdin {
+		t.Fatal("bad config", config.Memory, config.Tty, config.OpenStdin)
+	}
+
+	if _, ok := config.Volumes["/data"]; !ok || !reflect.DeepEqual(config.HostConfig.Binds, []string{"/srv:/srv:ro"}) {
+		t.Fatal("bad volumes", config.Volumes, config.HostConfig.Binds)
+	}
+
+	expected := map[dockerClient.Port][]dockerClient.PortBinding{
+		"80/tcp": {{HostPort: "8080"}},
+		"53/udp": {{HostIp: "127.0.0.1"}},
+	}
+	if !reflect.DeepEqual(config.HostConfig.PortBindings, expected) || len(config.ExposedPorts) != 3 {
+		t.Fatal("bad ports", config.HostConfig.PortBindings, config.ExposedPorts)
+	}
+
+	if config.HostConfig.NetworkMode != "host" || config.HostConfig.RestartPolicy.Name != "on-failure" || config.HostConfig.RestartPolicy.MaxRetry != 3 {
+		t.Fatal("bad host config", config.HostConfig)
+	}
+}
+
+func TestRunConfigFallback(t *testing.T) {
+	for _, args := range [][]string{
+		{"--cap-add", "NET_ADMIN", "nginx"},
+		{"--device", "/dev/fuse", "nginx"},
+		{"-p", "8000-8010:8000-8010", "nginx"},
+		{"-d"},
+		{"--name"},
+	} {
+		_, _, err := runConfig(&Context{}, args)
+		if _, ok := err.(*fallbackError); !ok {
+			t.Fatal("expected fallback for", args, err)
+		}
+	}
+
+	/* Anything after the image is the container's business */
+	if _, _, err := runConfig(&Context{}, []string{"nginx", "--cap-add", "x"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunConfigUnitFlags(t *testing.T) {
+	/* What --unit-user and --log-mode=journald add */
+	config, _, err := runConfig(&Context{}, []string{
+		"--user", "1000:1000", "--group-add", "10", "--group-add", "20",
+		"--log-driver=journald", "--log-opt", "tag=web.service", "--entrypoint", "", "nginx",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(config.HostConfig.GroupAdd, []string{"10", "20"}) {
+		t.Fatal("bad groups", config.HostConfig.GroupAdd)
+	}
+
+	if !reflect.DeepEqual(config.HostConfig.LogConfig, &logConfig{Type: "journald", Config: map[string]string{"tag": "web.service"}}) {
+		t.Fatal("bad log config", config.HostConfig.LogConfig)
+	}
+
+	data, _ := json.Marshal(config)
+	if !strings.Contains(string(data), `"Entrypoint":[]`) {
+		t.Fatal("an empty entrypoint should clear the image's", string(data))
+	}
+}
+
+func TestApiLaunchContainer(t *testing.T) {
+	var created createConfig
+	var hostConfig createHostConfig
+	started := false
+	badName := ""
+
+	client, done := fakeDocker(t, map[string]http.HandlerFunc{
+		"/containers/create": func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("name") != "web" {
+				badName = r.URL.String()
+			}
+			json.NewDecoder(r.Body).Decode(&created)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"Id":"abc"}`))
+		},
+		"/containers/abc/start": func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&hostConfig)
+			started = true
+			w.WriteHeader(http.StatusNoContent)
+		},
+		"/containers/abc/json": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"Id":"abc","State":{"Running":true,"Pid":42}}`))
+		},
+	})
+	defer done()
+
+	c := &Context{
+		Client:      client,
+		Labels:      true,
+		OwnerLabels: map[string]string{LABEL_UNIT: "web.service"},
+		Args:        []string{"-d", "--name", "web", "--group-add", "10", "nginx"},
+	}
+
+	if err := apiLaunchContainer(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(badName) > 0 {
+		t.Fatal("bad name", badName)
+	}
+
+	if !reflect.DeepEqual(hostConfig.GroupAdd, []string{"10"}) {
+		t.Fatal("start should send the whole host config", hostConfig)
+	}
+
+	if c.Id != "abc" || c.Pid != 42 || !started {
+		t.Fatal("container not started", c.Id, c.Pid, started)
+	}
+
+	if created.Labels[LABEL_UNIT] != "web.service" || len(created.Labels[LABEL_CONFIG_HASH]) == 0 {
+		t.Fatal("missing owner labels", created.Labels)
+	}
+}
+
+func TestApiLaunchMissingImage(t *testing.T) {
+	client, done := fakeDocker(t, map[string]http.HandlerFunc{
+		"/containers/create": func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "No such image: nginx", http.StatusNotFound)
+		},
+	})
+	defer done()
+
+	c := &Context{Client: client, Args: []string{"-d", "nginx"}}
+	if _, ok := apiLaunchContainer(c).(*fallbackError); !ok {
+		t.Fatal("a missing image should fall back to docker run")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var LOCK_POLL time.Duration = 100 * time.Millisecond
+
+func lockPath(name string) string {
+	return path.Join(STATE_DIR, "locks", containerName(name)+".lock")
+}
+
+/*
+ * lockContainer takes an exclusive lock on the container name so two
+ * units (or a unit and its ExecStartPre) using the same --name can't race
+ * between looking up the container and creating it.  The holder writes its
+ * pid and unit into the lock file so whoever times out can say who has it.
+ */
+func lockContainer(c *Context) (func(), error) {
+	if len(c.Name) == 0 {
+		return func() {}, nil
+	}
+
+	lockFile := lockPath(c.Name)
+	if err := os.MkdirAll(path.Dir(lockFile), 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(lockFile, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(c.LockTimeout)
+	for {
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+
+		if err == syscall.EINTR {
+			continue
+		}
+
+		if err != syscall.EWOULDBLOCK {
+			file.Close()
+			return nil, fmt.Errorf("Failed to lock container %s: %v", c.Name, err)
+		}
+
+		if time.Now().After(deadline) {
+			file.Close()
+			holder, _ := ioutil.ReadFile(lockFile)
+			return nil, fmt.Errorf("Timed out after %s waiting for lock on container %s, held by %s", c.LockTimeout, c.Name, lockHolder(string(holder)))
+		}
+
+		time.Sleep(LOCK_POLL)
+	}
+
+	file.Truncate(0)
+	file.WriteAt([]byte(fmt.Sprintf("pid %d unit %q\n", os.Getpid(), c.Unit)), 0)
+
+	return func() {
+		file.Truncate(0)
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+func lockHolder(holder string) string {
+	holder = strings.TrimSpace(holder)
+	if len(holder) == 0 {
+		return "an unknown process"
+	}
+	return holder
+}
+
+/* startContainer runs the container and takes over its cgroups while holding the name lock */
+func startContainer(c *Context) error {
+	unlock, err := lockContainer(c)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = runContainer(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = moveCgroups(c)
+	return err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockContainer(t *testing.T) {
+	withStateDir(t, func() {
+		c := &Context{Name: "app", Unit: "app.service", LockTimeout: time.Second}
+		unlock, err := lockContainer(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		other := &Context{Name: "app", Unit: "other.service", LockTimeout: 200 * time.Millisecond}
+		_, err = lockContainer(other)
+		if err == nil {
+			t.Fatal("lock should be held")
+		}
+
+		if !strings.Contains(err.Error(), `unit "app.service"`) {
+			t.Fatal("error should name the holder", err)
+		}
+
+		unlock()
+
+		unlock, err = lockContainer(other)
+		if err != nil {
+			t.Fatal("lock should be free", err)
+		}
+		unlock()
+	})
+}
+
+func TestLockUnnamed(t *testing.T) {
+	unlock, err := lockContainer(&Context{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
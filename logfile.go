@@ -0,0 +1,291 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/pkg/units"
+)
+
+const LOG_SINK_BUFFER = 1024
+
+/*
+ * rotatingFile is a log sink that keeps the raw container output in a file,
+ * rotating it to file.1, file.2, ... when it gets too big or too old.
+ */
+type rotatingFile struct {
+	Path     string
+	MaxSize  int64
+	MaxAge   time.Duration
+	MaxFiles int
+	Compress bool
+	now      func() time.Time
+	file     *os.File
+	size     int64
+	opened   time.Time
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.file = file
+	r.size = info.Size()
+	r.opened = r.now()
+	return nil
+}
+
+func (r *rotatingFile) segment(i int) string {
+	name := fmt.Sprintf("%s.%d", r.Path, i)
+	if r.Compress {
+		name += ".gz"
+	}
+	return name
+}
+
+func (r *rotatingFile) rotate() error {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+
+	os.Remove(r.segment(r.MaxFiles))
+	for i := r.MaxFiles - 1; i > 0; i-- {
+		os.Rename(r.segment(i), r.segment(i+1))
+	}
+
+	if r.MaxFiles > 0 {
+		if r.Compress {
+			if err := gzipFile(r.Path, r.segment(1)); err != nil {
+				return err
+			}
+		} else if err := os.Rename(r.Path, r.segment(1)); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Remove(r.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return r.open()
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if r.size > 0 && ((r.MaxSize > 0 && r.size+int64(len(p)) > r.MaxSize) ||
+		(r.MaxAge > 0 && r.now().Sub(r.opened) >= r.MaxAge)) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) Close() error {
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func gzipFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+/*
+ * asyncSink hands writes to a goroutine so a slow disk never holds up
+ * forwarding to the journal.  If the buffer fills up writes are dropped and
+ * a note is left in the sink once it catches up.
+ */
+type asyncSink struct {
+	out     io.Writer
+	queue   chan []byte
+	lock    sync.Mutex
+	dropped int
+	closed  bool
+	done    chan struct{}
+}
+
+func newAsyncSink(out io.Writer) *asyncSink {
+	s := &asyncSink{
+		out:   out,
+		queue: make(chan []byte, LOG_SINK_BUFFER),
+		done:  make(chan struct{}),
+	}
+
+	go s.run()
+	return s
+}
+
+func (s *asyncSink) run() {
+	defer close(s.done)
+
+	for p := range s.queue {
+		s.lock.Lock()
+		dropped := s.dropped
+		s.dropped = 0
+		s.lock.Unlock()
+
+		if dropped > 0 {
+			fmt.Fprintf(s.out, "systemd-docker: dropped %d writes to log file\n", dropped)
+		}
+
+		if _, err := s.out.Write(p); err != nil {
+			log.Println("Failed to write log file:", err)
+		}
+	}
+}
+
+func (s *asyncSink) Write(p []byte) (int, error) {
+	buf := make([]byte, len(p))
+	copy(buf, p)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return len(p), nil
+	}
+
+	select {
+	case s.queue <- buf:
+	default:
+		s.dropped++
+	}
+
+	return len(p), nil
+}
+
+/* Close waits for everything queued to be written and closes the sink */
+func (s *asyncSink) Close() error {
+	s.lock.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.lock.Unlock()
+
+	<-s.done
+
+	if closer, ok := s.out.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+/* teeWriter copies the raw stream to the extra sinks before filtering it for the journal */
+type teeWriter struct {
+	Out   io.Writer
+	Sinks []io.Writer
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	for _, sink := range t.Sinks {
+		sink.Write(p)
+	}
+	return t.Out.Write(p)
+}
+
+func (t *teeWriter) Flush() error {
+	if flusher, ok := t.Out.(logFlusher); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+/* logFilePath is where --log-file goes, a relative path is below LogsDirectory= */
+func logFilePath(c *Context) (string, error) {
+	if path.IsAbs(c.LogFile) {
+		return path.Clean(c.LogFile), nil
+	}
+
+	dir := os.Getenv("LOGS_DIRECTORY")
+	if len(dir) == 0 {
+		return "", fmt.Errorf("relative log file %s needs LogsDirectory= to be set", c.LogFile)
+	}
+	return path.Join(strings.Split(dir, ":")[0], c.LogFile), nil
+}
+
+func setupLogSinks(c *Context) error {
+	if len(c.LogFile) == 0 {
+		return nil
+	}
+
+	logFile, err := logFilePath(c)
+	if err != nil {
+		return err
+	}
+
+	maxSize, err := units.RAMInBytes(c.LogFileMaxSize)
+	if err != nil {
+		return err
+	}
+
+	file := &rotatingFile{
+		Path:     logFile,
+		MaxSize:  maxSize,
+		MaxAge:   c.LogFileMaxAge,
+		MaxFiles: c.LogFileMaxFiles,
+		Compress: c.LogFileCompress,
+		now:      time.Now,
+	}
+
+	if err := file.open(); err != nil {
+		return err
+	}
+
+	c.LogSinks = append(c.LogSinks, newAsyncSink(file))
+	return nil
+}
+
+func closeLogSinks(c *Context) {
+	for _, sink := range c.LogSinks {
+		if closer, ok := sink.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
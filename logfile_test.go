@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestRotatingFileSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := &rotatingFile{
+		Path:     path.Join(dir, "app.log"),
+		MaxSize:  10,
+		MaxFiles: 2,
+		now:      time.Now,
+	}
+
+	for _, line := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Close()
+
+	for name, expected := range map[string]string{
+		"app.log":   "dddddd\n",
+		"app.log.1": "cccccc\n",
+		"app.log.2": "bbbbbb\n",
+	} {
+		bytes, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(bytes) != expected {
+			t.Fatalf("%s: expected %q got %q", name, expected, string(bytes))
+		}
+	}
+
+	if _, err := os.Stat(path.Join(dir, "app.log.3")); !os.IsNotExist(err) {
+		t.Fatal("app.log.3 should not be kept")
+	}
+}
+
+func TestRotatingFileAgeCompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Unix(0, 0)
+	r := &rotatingFile{
+		Path:     path.Join(dir, "app.log"),
+		MaxAge:   time.Hour,
+		MaxFiles: 1,
+		Compress: true,
+		now:      func() time.Time { return now },
+	}
+
+	r.Write([]byte("old\n"))
+	now = now.Add(2 * time.Hour)
+	r.Write([]byte("new\n"))
+	r.Close()
+
+	file, err := os.Open(path.Join(dir, "app.log.1.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadAll(gz)
+	if err != nil || string(content) != "old\n" {
+		t.Fatal("bad rotated content", string(content), err)
+	}
+}
+
+func TestAsyncSink(t *testing.T) {
+	out := &bytes.Buffer{}
+	s := newAsyncSink(out)
+	s.Write([]byte("a\n"))
+	s.Write([]byte("b\n"))
+	s.Close()
+	s.Write([]byte("after close\n"))
+
+	if out.String() != "a\nb\n" {
+		t.Fatal("bad sink output", out.String())
+	}
+}
+
+func TestLogSinkRelativeNeedsDirectory(t *testing.T) {
+	os.Unsetenv("LOGS_DIRECTORY")
+	err := setupLogSinks(&Context{LogFile: "app.log", LogFileMaxSize: "1m"})
+	if err == nil {
+		t.Fatal("relative log file without LOGS_DIRECTORY should fail")
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+/*
+ * DEBUG_LINE matches the level field of a debug or trace line: a leading
+ * level after an optional timestamp, level=debug or "level":"debug".  A
+ * debug or trace anywhere else in the message doesn't count.
+ */
+var DEBUG_LINE = regexp.MustCompile(`(?i)^\s*(?:\d\S*\s+){0,2}[\[<(]?(?:debug|trace|dbg|trc)[\]>)]?(?::|\s)|\b(?:level|lvl|severity)=["']?(?:debug|trace)\b|"(?:level|lvl|severity)"\s*:\s*"(?:debug|trace)"`)
+
+/* LOG_LINE_MAX is the longest line we buffer, longer ones are split like journald does */
+var LOG_LINE_MAX = 48 * 1024
+
+/* logFlusher is implemented by log stream writers that buffer partial lines */
+type logFlusher interface {
+	Flush() error
+}
+
+type lineBuffer struct {
+	partial []byte
+}
+
+/*
+ * lines calls fn for every complete line, newline included, buffering the
+ * remainder.  Longer lines are cut into LOG_LINE_MAX long pieces so that
+ * output without newlines can't grow the buffer without bound.
+ */
+func (b *lineBuffer) lines(p []byte, fn func([]byte) error) error {
+	b.partial = append(b.partial, p...)
+	for {
+		i := bytes.IndexByte(b.partial, '\n')
+		if i < 0 && len(b.partial) < LOG_LINE_MAX {
+			return nil
+		}
+
+		if i < 0 || i > LOG_LINE_MAX {
+			line := append(b.partial[:LOG_LINE_MAX:LOG_LINE_MAX], '\n')
+			b.partial = b.partial[LOG_LINE_MAX:]
+			if err := fn(line); err != nil {
+				return err
+			}
+			continue
+		}
+
+		line := b.partial[:i+1]
+		b.partial = b.partial[i+1:]
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+}
+
+/* rest calls fn with whatever is left in the buffer, terminated with a newline */
+func (b *lineBuffer) rest(fn func([]byte) error) error {
+	if len(b.partial) == 0 {
+		return nil
+	}
+
+	line := append(b.partial, '\n')
+	b.partial = nil
+	return fn(line)
+}
+
+/* newLogStream wraps a journal bound stream with the configured filters */
+func newLogStream(c *Context, out io.Writer) io.Writer {
+	out = newLogLimiter(c, out)
+	if c.RedactLogs {
+		out = newRedactWriter(c.Redactor, out)
+	}
+	return out
+}
+
+/*
+ * logLimiter sits between the docker log stream and the journal.  It splits
+ * the stream into lines and enforces a burst of RateBurst lines per
+ * RateInterval so that a single chatty container can't trip journald's
+ * global rate limit and have messages from other units dropped.
+ */
+type logLimiter struct {
+	Out          io.Writer
+	RateBurst    int
+	RateInterval time.Duration
+	DebugSample  int
+	now          func() time.Time
+	lock         sync.Mutex
+	buf          lineBuffer
+	windowStart  time.Time
+	windowEnd    *time.Timer
+	windows      int
+	count        int
+	suppressed   int
+	debugSeen    int
+}
+
+func newLogLimiter(c *Context, out io.Writer) io.Writer {
+	if c.LogRateBurst <= 0 && c.LogDebugSample <= 1 {
+		return out
+	}
+
+	return &logLimiter{
+		Out:          out,
+		RateBurst:    c.LogRateBurst,
+		RateInterval: c.LogRateInterval,
+		DebugSample:  c.LogDebugSample,
+		now:          time.Now,
+	}
+}
+
+func (l *logLimiter) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if err := l.buf.lines(p, l.writeLine); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (l *logLimiter) writeLine(line []byte) error {
+	if l.DebugSample > 1 && DEBUG_LINE.Match(line) {
+		l.debugSeen++
+		if l.debugSeen%l.DebugSample != 1 {
+			return nil
+		}
+	}
+
+	if l.RateBurst > 0 {
+		now := l.now()
+		if l.windowStart.IsZero() || now.Sub(l.windowStart) >= l.RateInterval {
+			if err := l.flushSuppressed(); err != nil {
+				return err
+			}
+			l.windowStart = now
+			l.count = 0
+		}
+
+		if l.count >= l.RateBurst {
+			/* Write the summary when the window ends even if the container goes quiet */
+			if l.windowEnd == nil {
+				l.windows++
+				window := l.windows
+				l.windowEnd = time.AfterFunc(l.RateInterval-now.Sub(l.windowStart), func() {
+					l.endWindow(window)
+				})
+			}
+			l.suppressed++
+			return nil
+		}
+		l.count++
+	}
+
+	_, err := l.Out.Write(line)
+	return err
+}
+
+/* endWindow writes the suppressed summary once the rate limit window is over */
+func (l *logLimiter) endWindow(window int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	/* A new window has started since this timer fired */
+	if l.windowEnd == nil || l.windows != window {
+		return
+	}
+
+	l.windowEnd = nil
+	l.windowStart = time.Time{}
+	l.flushSuppressed()
+}
+
+func (l *logLimiter) flushSuppressed() error {
+	if l.windowEnd != nil {
+		l.windowEnd.Stop()
+		l.windowEnd = nil
+	}
+
+	if l.suppressed == 0 {
+		return nil
+	}
+
+	_, err := fmt.Fprintf(l.Out, "systemd-docker: suppressed %d messages\n", l.suppressed)
+	l.suppressed = 0
+	return err
+}
+
+/* Flush writes out any trailing partial line and the final suppressed summary */
+func (l *logLimiter) Flush() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if err := l.buf.rest(l.writeLine); err != nil {
+		return err
+	}
+
+	return l.flushSuppressed()
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogLimiterPassthrough(t *testing.T) {
+	out := &bytes.Buffer{}
+	if w := newLogLimiter(&Context{}, out); w != out {
+		t.Fatal("limiter should not wrap the writer when disabled")
+	}
+}
+
+func TestLogLimiterBurst(t *testing.T) {
+	out := &bytes.Buffer{}
+	now := time.Unix(0, 0)
+	l := newLogLimiter(&Context{LogRateBurst: 2, LogRateInterval: time.Second}, out).(*logLimiter)
+	l.now = func() time.Time { return now }
+
+	l.Write([]byte("a\nb\nc\nd"))
+	l.Write([]byte("\n"))
+
+	now = now.Add(2 * time.Second)
+	l.Write([]byte("e\n"))
+	l.Flush()
+
+	expected := "a\nb\nsystemd-docker: suppressed 2 messages\ne\n"
+	if out.String() != expected {
+		t.Fatalf("expected %q got %q", expected, out.String())
+	}
+}
+
+func TestLogLimiterSummaryOnClose(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := newLogLimiter(&Context{LogRateBurst: 1, LogRateInterval: time.Hour}, out).(*logLimiter)
+
+	l.Write([]byte("a\nb\nc"))
+	l.Flush()
+
+	if !strings.HasSuffix(out.String(), "suppressed 2 messages\n") {
+		t.Fatal("missing summary", out.String())
+	}
+}
+
+func TestLogLimiterDebugSample(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := newLogLimiter(&Context{LogDebugSample: 3}, out)
+
+	for i := 0; i < 6; i++ {
+		l.Write([]byte("DEBUG noise\n"))
+	}
+	l.Write([]byte("info line\n"))
+
+	if out.String() != "DEBUG noise\nDEBUG noise\ninfo line\n" {
+		t.Fatal("bad sampling", out.String())
+	}
+}
+
+func TestParseLogRate(t *testing.T) {
+	c, err := parseContext([]string{"--log-rate-burst=10", "--log-rate-interval=5s", "--log-sample-debug=4", "run"})
+	if err != nil {
+		t.Fatal("failed to parse:", err)
+	}
+
+	if c.LogRateBurst != 10 || c.LogRateInterval != 5*time.Second || c.LogDebugSample != 4 {
+		t.Fatal("bad log rate options", c.LogRateBurst, c.LogRateInterval, c.LogDebugSample)
+	}
+
+	for _, interval := range []string{"0", "-5s"} {
+		if _, err := parseContext([]string{"--log-rate-burst=10", "--log-rate-interval=" + interval, "run"}); err == nil {
+			t.Fatal("should need a positive interval", interval)
+		}
+	}
+
+	if _, err := parseContext([]string{"--log-rate-interval=0", "run"}); err != nil {
+		t.Fatal("the interval doesn't matter without a burst", err)
+	}
+}
+
+func TestParseLogModeJournald(t *testing.T) {
+	withCgroupFile(t, "0::/system.slice/nginx.service\n", func() {
+		c, err := parseContext([]string{"--log-mode=journald", "run", "nginx"})
+		if err != nil {
+			t.Fatal("failed to parse:", err)
+		}
+
+		if c.Args[0] != "--log-driver=journald" ||
+			c.Args[1] != "--log-opt" ||
+			c.Args[2] != "tag=nginx.service" {
+			t.Fatal("Invalid args", c.Args)
+		}
+	})
+}
+
+func TestParseLogModeInvalid(t *testing.T) {
+	_, err := parseContext([]string{"--log-mode=bad", "run", "nginx"})
+	if err == nil {
+		t.Fatal("parse should fail on a bad log mode")
+	}
+}
+
+func TestPipeLogsSkipsDriver(t *testing.T) {
+	for _, driver := range []string{"journald", "syslog", "none"} {
+		c := &Context{Logs: true, LogDriver: driver}
+		if err := pipeLogs(c); err != nil {
+			t.Fatal("pipeLogs should skip driver", driver, err)
+		}
+	}
+}
+
+func TestLogLimiterSummaryWhenQuiet(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := newLogLimiter(&Context{LogRateBurst: 1, LogRateInterval: 50 * time.Millisecond}, out).(*logLimiter)
+	written := func() string {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		return out.String()
+	}
+
+	l.Write([]byte("a\nb\nc\n"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.HasSuffix(written(), "suppressed 2 messages\n") {
+		if time.Now().After(deadline) {
+			t.Fatal("no summary after the window", written())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	l.Write([]byte("d\n"))
+	l.Flush()
+	if out.String() != "a\nsystemd-docker: suppressed 2 messages\nd\n" {
+		t.Fatal("bad output", out.String())
+	}
+}
+
+func TestLogLimiterDebugLevel(t *testing.T) {
+	for _, line := range []string{
+		"DEBUG noise",
+		"[debug] noise",
+		"2024-01-01T00:00:00Z TRACE noise",
+		"2024-01-01 00:00:00 debug: noise",
+		"time=now level=debug msg=noise",
+		`{"level":"debug","msg":"noise"}`,
+	} {
+		if !DEBUG_LINE.MatchString(line + "\n") {
+			t.Fatal("should be a debug line", line)
+		}
+	}
+
+	for _, line := range []string{
+		"ERROR failed to enable debug mode",
+		"level=error msg=\"trace follows\"",
+		"debugger attached",
+	} {
+		if DEBUG_LINE.MatchString(line + "\n") {
+			t.Fatal("should not be a debug line", line)
+		}
+	}
+}
+
+func TestLineBufferMax(t *testing.T) {
+	old := LOG_LINE_MAX
+	LOG_LINE_MAX = 4
+	defer func() { LOG_LINE_MAX = old }()
+
+	lines := []string{}
+	b := &lineBuffer{}
+	b.lines([]byte("abcdefghij\nk"), func(line []byte) error {
+		lines = append(lines, string(line))
+		return nil
+	})
+
+	if strings.Join(lines, "|") != "abcd\n|efgh\n|ij\n" || string(b.partial) != "k" {
+		t.Fatal("bad lines", lines, string(b.partial))
+	}
+}
+
+func TestCheckLogDriver(t *testing.T) {
+	_, done := fakeDocker(t, map[string]http.HandlerFunc{
+		"/containers/abc/json": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"Id":"abc","HostConfig":{"LogConfig":{"Type":"journald"}}}`))
+		},
+	})
+	defer done()
+
+	c := &Context{Logs: true, Id: "abc"}
+	checkLogDriver(c)
+	if c.LogDriver != "journald" {
+		t.Fatal("bad log driver", c.LogDriver)
+	}
+}
+
+func TestParseLogFileDriver(t *testing.T) {
+	for _, args := range [][]string{
+		{"--log-file=/tmp/app.log", "--logs=false", "run", "nginx"},
+		{"--log-file=/tmp/app.log", "run", "--log-driver=syslog", "nginx"},
+		{"--log-file=/tmp/app.log", "run", "--log-driver", "none", "nginx"},
+	} {
+		if _, err := parseContext(args); err == nil {
+			t.Fatal("should refuse a log file that would stay empty", args)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--log-file=/tmp/app.log", "--log-mode=journald", "run", "nginx"},
+		{"--log-file=/tmp/app.log", "run", "--log-driver=local", "nginx"},
+	} {
+		if _, err := parseContext(args); err != nil {
+			t.Fatal("should allow a log file", args, err)
+		}
+	}
+}
+
+func TestPipeLogsJournaldSinks(t *testing.T) {
+	_, done := fakeDocker(t, map[string]http.HandlerFunc{
+		"/containers/abc/logs": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/vnd.docker.raw-stream")
+			w.Write([]byte{1, 0, 0, 0, 0, 0, 0, 6})
+			w.Write([]byte("hello\n"))
+		},
+	})
+	defer done()
+
+	sink := &bytes.Buffer{}
+	c := &Context{Logs: true, Id: "abc", LogDriver: "journald", LogSinks: []io.Writer{sink}}
+	if err := pipeLogs(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if sink.String() != "hello\n" {
+		t.Fatal("the log file should still get the logs", sink.String())
+	}
+}
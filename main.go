@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/exec"
 	"path"
@@ -29,20 +30,76 @@ var (
 )
 
 type Context struct {
-	Args         []string
-	Cgroups      []string
-	AllCgroups   bool
-	Logs         bool
-	Notify       bool
-	Name         string
-	Env          bool
-	Rm           bool
-	Id           string
-	NotifySocket string
-	Cmd          *exec.Cmd
-	Pid          int
-	PidFile      string
-	Client       *dockerClient.Client
+	Args             []string
+	Cgroups          []string
+	AllCgroups       bool
+	Logs             bool
+	Notify           bool
+	Name             string
+	Env              bool
+	Rm               bool
+	Id               string
+	NotifySocket     string
+	Cmd              *exec.Cmd
+	Pid              int
+	PidFile          string
+	Client           *dockerClient.Client
+	LogRateBurst     int
+	LogRateInterval  time.Duration
+	LogDebugSample   int
+	Redactor         *redactor
+	RedactLogs       bool
+	LogMode          string
+	LogDriver        string
+	TailFiles        []string
+	LogFile          string
+	LogFileMaxSize   string
+	LogFileMaxAge    time.Duration
+	LogFileMaxFiles  int
+	LogFileCompress  bool
+	LogSinks         []io.Writer
+	EnvInclude       []string
+	EnvExclude       []string
+	EnvPrefix        string
+	EnvStripPrefix   bool
+	Credentials      bool
+	CredentialsPath  string
+	CredentialsOwner string
+	CredentialNames  []string
+	CredentialEnv    []string
+	CredentialsCopy  string
+	Labels           bool
+	Unit             string
+	MachineId        string
+	OwnerLabels      map[string]string
+	AutoName         bool
+	OnDrift          string
+	Adopt            bool
+	LockTimeout      time.Duration
+	Image            string
+	Api              bool
+	Pull             string
+	PullRetries      int
+	RegistryConfig   string
+	ImageArchive     string
+	PinDigest        string
+	UpdateDigest     bool
+	ImageDigest      string
+	UnitUser         bool
+	MountUnitDirs    bool
+	UnitDirTargets   []string
+	Sockets          []*os.File
+	SocketPorts      map[string]int
+}
+
+/* exitError is an error that should end systemd-docker with a specific exit status */
+type exitError struct {
+	Code int
+	Err  error
+}
+
+func (e *exitError) Error() string {
+	return e.Err.Error()
 }
 
 func setupEnvironment(c *Context) {
@@ -54,14 +111,6 @@ func setupEnvironment(c *Context) {
 		c.Notify = false
 	}
 
-	if c.Env {
-		for _, val := range os.Environ() {
-			if !strings.HasPrefix(val, "HOME=") && !strings.HasPrefix(val, "PATH=") {
-				newArgs = append(newArgs, "-e", val)
-			}
-		}
-	}
-
 	if len(newArgs) > 0 {
 		c.Args = append(newArgs, c.Args...)
 	}
@@ -76,20 +125,76 @@ func parseContext(args []string) (*Context, error) {
 	flags := flag.NewFlagSet("systemd-docker", flag.ContinueOnError)
 
 	flCgroups := opts.NewListOpts(nil)
+	flRedact := opts.NewListOpts(nil)
+	flRedactRegex := opts.NewListOpts(nil)
+	flTailFiles := opts.NewListOpts(nil)
+	flEnvInclude := opts.NewListOpts(nil)
+	flEnvExclude := opts.NewListOpts(nil)
+	flCredentialNames := opts.NewListOpts(nil)
+	flCredentialEnv := opts.NewListOpts(nil)
+	flUnitDirTargets := opts.NewListOpts(nil)
+	flSocketPorts := opts.NewListOpts(nil)
 
 	flags.StringVar(&c.PidFile, []string{"p", "-pid-file"}, "", "pipe file")
 	flags.BoolVar(&c.Logs, []string{"l", "-logs"}, true, "pipe logs")
 	flags.BoolVar(&c.Notify, []string{"n", "-notify"}, false, "setup systemd notify for container")
 	flags.BoolVar(&c.Env, []string{"e", "-env"}, false, "inherit environment variable")
+	flags.Var(&flEnvInclude, []string{"-env-include"}, "only inherit environment variables matching this glob")
+	flags.Var(&flEnvExclude, []string{"-env-exclude"}, "don't inherit environment variables matching this glob")
+	flags.StringVar(&c.EnvPrefix, []string{"-env-prefix"}, "", "only inherit environment variables with this prefix, implies --env")
+	flags.BoolVar(&c.EnvStripPrefix, []string{"-env-strip-prefix"}, false, "remove --env-prefix from the inherited variable names")
+	flags.BoolVar(&c.Credentials, []string{"-credentials"}, false, "mount the unit's systemd credentials into the container")
+	flags.StringVar(&c.CredentialsPath, []string{"-credentials-path"}, "/run/credentials", "where to mount the credentials in the container")
+	flags.StringVar(&c.CredentialsOwner, []string{"-credentials-owner"}, "", "uid:gid that should own the mounted credentials")
+	flags.Var(&flCredentialNames, []string{"-credential"}, "only mount this credential")
+	flags.Var(&flCredentialEnv, []string{"-credential-env"}, "NAME[=VAR] to pass credential NAME as environment variable VAR")
 	flags.Var(&flCgroups, []string{"c", "-cgroups"}, "cgroups to take ownership of or 'all' for all cgroups available")
+	flags.IntVar(&c.LogRateBurst, []string{"-log-rate-burst"}, 0, "max log lines per stream in each interval, 0 for unlimited")
+	flags.DurationVar(&c.LogRateInterval, []string{"-log-rate-interval"}, 30*time.Second, "log rate limit interval")
+	flags.IntVar(&c.LogDebugSample, []string{"-log-sample-debug"}, 0, "only forward one in N debug log lines")
+	flags.Var(&flRedact, []string{"-redact"}, "additional variable names whose values should be masked in output")
+	flags.Var(&flRedactRegex, []string{"-redact-regex"}, "regex of text to mask in output, only capture groups are masked if present")
+	flags.BoolVar(&c.RedactLogs, []string{"-redact-logs"}, false, "also mask secrets in container logs")
+	flags.Var(&flTailFiles, []string{"-tail-file"}, "file or glob inside the container to forward to the journal")
+	flags.BoolVar(&c.Labels, []string{"-labels"}, true, "label the container with the unit that owns it")
+	flags.StringVar(&c.OnDrift, []string{"-on-drift"}, "ignore", "what to do when a named container no longer matches the run arguments or image: 'ignore', 'recreate' or 'refuse'")
+	flags.BoolVar(&c.Adopt, []string{"-adopt"}, false, "take over a running container with the same name even if another unit owns it")
+	flags.DurationVar(&c.LockTimeout, []string{"-lock-timeout"}, time.Minute, "how long to wait for another systemd-docker starting a container with the same name")
+	flags.BoolVar(&c.AutoName, []string{"-auto-name"}, true, "name the container after the unit if --name is not given")
+	flags.StringVar(&c.LogFile, []string{"-log-file"}, "", "also write container output to this file, relative to LogsDirectory= if not absolute")
+	flags.StringVar(&c.LogFileMaxSize, []string{"-log-file-max-size"}, "100m", "rotate the log file when it reaches this size")
+	flags.DurationVar(&c.LogFileMaxAge, []string{"-log-file-max-age"}, 0, "rotate the log file when it gets this old, 0 to disable")
+	flags.IntVar(&c.LogFileMaxFiles, []string{"-log-file-max-files"}, 5, "number of rotated log files to keep")
+	flags.BoolVar(&c.LogFileCompress, []string{"-log-file-compress"}, false, "gzip rotated log files")
+	flags.BoolVar(&c.Api, []string{"-api"}, false, "create the container through the Docker API, falling back to docker run for flags it can't translate")
+	flags.StringVar(&c.Pull, []string{"-pull"}, "", "'always', 'missing' or 'never' to pull the image before starting instead of leaving it to docker run")
+	flags.IntVar(&c.PullRetries, []string{"-pull-retries"}, 3, "how many times to retry a pull that failed with a transient error")
+	flags.StringVar(&c.RegistryConfig, []string{"-registry-config"}, "", "docker client config.json with registry credentials, defaults to ~/.docker/config.json")
+	flags.StringVar(&c.ImageArchive, []string{"-image-archive"}, "", "docker save archive to load the image from if it isn't there")
+	flags.StringVar(&c.PinDigest, []string{"-pin-digest"}, "", "'record' to record the image digest at first start and warn when it changes, 'enforce' to refuse to start when it changes")
+	flags.BoolVar(&c.UpdateDigest, []string{"-update-digest"}, false, "accept a new image digest with --pin-digest=enforce")
+	flags.BoolVar(&c.UnitUser, []string{"-unit-user"}, false, "run the container as the uid, gid and groups of the unit's User=")
+	flags.BoolVar(&c.MountUnitDirs, []string{"-mount-unit-dirs"}, false, "bind mount the unit's StateDirectory=, CacheDirectory=, LogsDirectory=, RuntimeDirectory= and ConfigurationDirectory=")
+	flags.Var(&flUnitDirTargets, []string{"-unit-dir-target"}, "NAME=PATH to mount the unit's state, cache, logs, runtime or configuration directory at PATH")
+	flags.Var(&flSocketPorts, []string{"-socket-port"}, "[NAME=]PORT container port to relay socket activated connections to, defaults to the port of the socket")
+	flags.StringVar(&c.LogMode, []string{"-log-mode"}, "auto", "'auto' to pipe logs unless the log driver already writes to journald, 'journald' to use the journald log driver instead of piping")
 
 	err := flags.Parse(args)
 	if err != nil {
 		return nil, err
 	}
 
-	foundD := false
-	var name string
+	/* A window that is over as soon as it starts would never limit anything */
+	if c.LogRateBurst > 0 && c.LogRateInterval <= 0 {
+		return nil, fmt.Errorf("invalid log rate interval %v, --log-rate-burst needs a positive interval", c.LogRateInterval)
+	}
+
+	c.Redactor, err = newRedactor(flRedact.GetAll(), flRedactRegex.GetAll())
+	if err != nil {
+		return nil, err
+	}
+
+	log.SetOutput(newRedactWriter(c.Redactor, os.Stderr))
 
 	runArgs := flags.Args()
 	if len(runArgs) == 0 || runArgs[0] != "run" {
@@ -97,40 +202,104 @@ func parseContext(args []string) (*Context, error) {
 		return nil, errors.New("run not found in arguments")
 	}
 
-	runArgs = runArgs[1:]
-	newArgs := make([]string, 0, len(runArgs))
-
-	for i, arg := range runArgs {
-		/* This is tedious, but flag can't ignore unknown flags and I don't want to define them all */
-		add := true
-
-		switch {
-		case arg == "-rm" || arg == "--rm":
-			c.Rm = true
-			add = false
-		case arg == "-d" || arg == "-detach" || arg == "--detach":
-			foundD = true
-		case strings.HasPrefix(arg, "-name") || strings.HasPrefix(arg, "--name"):
-			if strings.Contains(arg, "=") {
-				name = strings.SplitN(arg, "=", 2)[1]
-			} else if len(runArgs) > i+1 {
-				name = runArgs[i+1]
-			}
-		}
+	run := parseRunArgs(runArgs[1:])
+
+	/* systemd-docker handles --rm itself and always runs the container detached */
+	c.Rm = run.Bool("rm")
+	run.Remove("rm")
+	run.Remove("detach")
+
+	name, _ := run.Get("name")
+	newArgs := append([]string{"-d"}, run.Args()...)
+
+	switch c.OnDrift {
+	case "ignore", "recreate", "refuse":
+	default:
+		return nil, fmt.Errorf("invalid drift policy %s", c.OnDrift)
+	}
+
+	switch c.PinDigest {
+	case "", "record", "enforce":
+	default:
+		return nil, fmt.Errorf("invalid digest pinning %s", c.PinDigest)
+	}
 
-		if add {
-			newArgs = append(newArgs, arg)
+	switch c.Pull {
+	case "", "always", "missing", "never":
+	default:
+		return nil, fmt.Errorf("invalid pull policy %s", c.Pull)
+	}
+
+	c.Unit = getUnitName()
+	c.MachineId = getMachineId()
+
+	err = checkPolicy(c, run)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := setupIdentity(c, run)
+	if err != nil {
+		return nil, err
+	}
+	newArgs = append(identity, newArgs...)
+
+	if len(name) == 0 && c.AutoName && len(c.Unit) > 0 {
+		name = containerName(c.Unit)
+		newArgs = append([]string{"--name", name}, newArgs...)
+	}
+
+	switch c.LogMode {
+	case "auto":
+	case "journald":
+		logArgs := []string{"--log-driver=journald"}
+		if len(c.Unit) > 0 {
+			logArgs = append(logArgs, "--log-opt", "tag="+c.Unit)
 		}
+		newArgs = append(logArgs, newArgs...)
+	default:
+		return nil, fmt.Errorf("invalid log mode %s", c.LogMode)
 	}
 
-	if !foundD {
-		newArgs = append([]string{"-d"}, newArgs...)
+	if len(c.LogFile) > 0 {
+		driver, _ := run.Get("log-driver")
+		if c.LogMode == "journald" {
+			driver = "journald"
+		}
+
+		if !c.Logs {
+			return nil, errors.New("--log-file needs --logs, the container output is written to it while piping")
+		}
+		if len(driver) > 0 && !logsReadable(driver) {
+			return nil, fmt.Errorf("--log-file can not be used with log driver %s, its logs can not be read", driver)
+		}
 	}
 
 	c.Name = name
+	c.Image = run.Image
 	c.NotifySocket = os.Getenv("NOTIFY_SOCKET")
+
+	c.SocketPorts, err = parseSocketPorts(flSocketPorts.GetAll())
+	if err != nil {
+		return nil, err
+	}
+
+	c.Sockets, err = inheritedSockets()
+	if err != nil {
+		return nil, err
+	}
 	c.Args = newArgs
 	c.Cgroups = flCgroups.GetAll()
+	c.TailFiles = flTailFiles.GetAll()
+	c.EnvInclude = flEnvInclude.GetAll()
+	c.EnvExclude = flEnvExclude.GetAll()
+	c.CredentialNames = flCredentialNames.GetAll()
+	c.CredentialEnv = flCredentialEnv.GetAll()
+	c.UnitDirTargets = flUnitDirTargets.GetAll()
+
+	if len(c.EnvPrefix) > 0 {
+		c.Env = true
+	}
 
 	for _, val := range c.Cgroups {
 		if val == "all" {
@@ -141,6 +310,7 @@ func parseContext(args []string) (*Context, error) {
 	}
 
 	setupEnvironment(c)
+	setupLabels(c, args)
 
 	return c, nil
 }
@@ -160,14 +330,25 @@ func lookupNamedContainer(c *Context) error {
 	}
 
 	if container.State.Running {
+		err = checkOwner(c, container.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	recreate, err := checkDrift(c, container)
+	if err != nil {
+		return err
+	}
+
+	if recreate {
+		return removeContainer(c, container.ID, false)
+	} else if container.State.Running {
 		c.Id = container.ID
 		c.Pid = container.State.Pid
 		return nil
 	} else if c.Rm {
-		return client.RemoveContainer(dockerClient.RemoveContainerOptions{
-			ID:    container.ID,
-			Force: true,
-		})
+		return removeContainer(c, container.ID, false)
 	} else {
 		client, err := getClient(c)
 		err = client.StartContainer(container.ID, container.HostConfig)
@@ -188,7 +369,20 @@ func lookupNamedContainer(c *Context) error {
 }
 
 func launchContainer(c *Context) error {
-	args := append([]string{"run"}, c.Args...)
+	args := append([]string{"run"}, labelArgs(c)...)
+
+	if c.Env || len(c.CredentialEnv) > 0 {
+		envFile, err := writeEnvFile(c)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(envFile)
+
+		args = append(args, "--env-file", envFile)
+	}
+
+	args = append(args, c.Args...)
+
 	c.Cmd = exec.Command("docker", args...)
 
 	errorPipe, err := c.Cmd.StderrPipe()
@@ -206,7 +400,7 @@ func launchContainer(c *Context) error {
 		return err
 	}
 
-	go io.Copy(os.Stderr, errorPipe)
+	go io.Copy(newRedactWriter(c.Redactor, os.Stderr), errorPipe)
 
 	bytes, err := ioutil.ReadAll(outputPipe)
 	if err != nil {
@@ -238,6 +432,15 @@ func runContainer(c *Context) error {
 
 	}
 
+	if len(c.Id) == 0 && c.Api {
+		err := apiLaunchContainer(c)
+		if e, ok := err.(*fallbackError); ok {
+			log.Println("Using docker run,", e)
+		} else if err != nil {
+			return err
+		}
+	}
+
 	if len(c.Id) == 0 {
 		err := launchContainer(c)
 		if err != nil {
@@ -257,12 +460,24 @@ func getClient(c *Context) (*dockerClient.Client, error) {
 		return c.Client, nil
 	}
 
-	endpoint := os.Getenv("DOCKER_HOST")
-	if len(endpoint) == 0 {
-		endpoint = "unix:///var/run/docker.sock"
+	/* The vendored client knows nothing about TLS, give it our transport */
+	transport, base, err := dockerTransport()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := dockerEndpoint()
+	if strings.HasPrefix(base, "https://") {
+		endpoint = base
 	}
 
-	return dockerClient.NewVersionedClient(endpoint, "1.12")
+	client, err := dockerClient.NewVersionedClient(endpoint, API_VERSION)
+	if err != nil {
+		return nil, err
+	}
+
+	client.HTTPClient = &http.Client{Transport: transport}
+	return client, nil
 }
 
 func getContainerPid(c *Context) (int, error) {
@@ -448,6 +663,22 @@ func notify(c *Context) error {
 	return nil
 }
 
+/* sdNotify sends a single state like STATUS=... to systemd, if we were started with a notify socket */
+func sdNotify(c *Context, state string) error {
+	if len(c.NotifySocket) == 0 {
+		return nil
+	}
+
+	conn, err := net.Dial("unixgram", c.NotifySocket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
 func pidFile(c *Context) error {
 	if len(c.PidFile) == 0 || c.Pid <= 0 {
 		return nil
@@ -461,30 +692,123 @@ func pidFile(c *Context) error {
 	return nil
 }
 
+/* containerDetails is the part of docker inspect the vendored client doesn't know about */
+type containerDetails struct {
+	Config struct {
+		Labels map[string]string
+	}
+	HostConfig struct {
+		NetworkMode string
+		LogConfig   struct {
+			Type string
+		}
+	}
+	NetworkSettings struct {
+		IPAddress string
+		Networks  map[string]struct {
+			IPAddress string
+		}
+	}
+}
+
+func inspectDetails(id string) (*containerDetails, error) {
+	details := &containerDetails{}
+	if err := dockerRequest("GET", "/containers/"+id+"/json", nil, details); err != nil {
+		return nil, err
+	}
+
+	return details, nil
+}
+
+/* logsReadable tells whether the Logs API can read back what the log driver wrote */
+func logsReadable(driver string) bool {
+	switch driver {
+	case "", "json-file", "local", "journald":
+		return true
+	}
+	return false
+}
+
+/*
+ * checkLogDriver decides whether pipeLogs has anything to do.  If the log
+ * driver already sends everything to journald piping would just duplicate
+ * every line, and for drivers like syslog or none the Logs API has nothing
+ * to give us.
+ */
+func checkLogDriver(c *Context) {
+	if !c.Logs {
+		return
+	}
+
+	details, err := inspectDetails(c.Id)
+	if err != nil {
+		log.Println("Failed to find log driver, piping logs anyway:", err)
+		return
+	}
+
+	driver := details.HostConfig.LogConfig.Type
+	c.LogDriver = driver
+
+	switch driver {
+	case "", "json-file", "local":
+	case "journald":
+		log.Printf("Container %s logs to journald, not piping logs\n", c.Id)
+	default:
+		log.Printf("Container %s uses log driver %s, logs can not be read and will not be piped\n", c.Id, driver)
+	}
+}
+
 func pipeLogs(c *Context) error {
 	if !c.Logs {
 		return nil
 	}
 
+	var stdout, stderr io.Writer
+	switch c.LogDriver {
+	case "", "json-file", "local":
+		stdout = newLogStream(c, os.Stdout)
+		stderr = newLogStream(c, os.Stderr)
+	case "journald":
+		/* The journal has them already, only the sinks still need them */
+		if len(c.LogSinks) == 0 {
+			return nil
+		}
+		stdout, stderr = ioutil.Discard, ioutil.Discard
+	default:
+		return nil
+	}
+
 	client, err := getClient(c)
 	if err != nil {
 		return err
 	}
 
+	if len(c.LogSinks) > 0 {
+		stdout = &teeWriter{Out: stdout, Sinks: c.LogSinks}
+		stderr = &teeWriter{Out: stderr, Sinks: c.LogSinks}
+	}
+
 	err = client.Logs(dockerClient.LogsOptions{
 		Container:    c.Id,
 		Follow:       true,
 		Stdout:       true,
 		Stderr:       true,
-		OutputStream: os.Stdout,
-		ErrorStream:  os.Stderr,
+		OutputStream: stdout,
+		ErrorStream:  stderr,
 	})
 
+	for _, w := range []io.Writer{stdout, stderr} {
+		if flusher, ok := w.(logFlusher); ok {
+			flusher.Flush()
+		}
+	}
+
 	return err
 }
 
 func keepAlive(c *Context) error {
-	if c.Logs || c.Rm {
+	/* The socket relays are goroutines, they stop when we exit */
+	if c.Logs || c.Rm || len(c.Sockets) > 0 {
 		client, err := getClient(c)
 		if err != nil {
 			return err
@@ -508,34 +832,74 @@ func keepAlive(c *Context) error {
 	return nil
 }
 
-func rmContainer(c *Context) error {
-	if !c.Rm {
-		return nil
-	}
-
+/* removeContainer force removes a container and forgets who owned it */
+func removeContainer(c *Context, id string, volumes bool) error {
 	client, err := getClient(c)
 	if err != nil {
 		return err
 	}
 
+	os.Remove(ownerRecord(id))
+
 	return client.RemoveContainer(dockerClient.RemoveContainerOptions{
-		ID:    c.Id,
-		Force: true,
+		ID:            id,
+		RemoveVolumes: volumes,
+		Force:         true,
 	})
 }
 
+func rmContainer(c *Context) error {
+	if !c.Rm {
+		return nil
+	}
+
+	return removeContainer(c, c.Id, false)
+}
+
 func mainWithArgs(args []string) (*Context, error) {
 	c, err := parseContext(args)
 	if err != nil {
 		return c, err
 	}
 
-	err = runContainer(c)
+	err = setupLogSinks(c)
+	if err != nil {
+		return c, err
+	}
+	defer closeLogSinks(c)
+
+	err = setupCredentials(c)
+	if err != nil {
+		return c, err
+	}
+	defer removeCredentials(c)
+
+	err = setupUnitDirs(c)
+	if err != nil {
+		return c, err
+	}
+
+	err = loadImageArchive(c)
 	if err != nil {
 		return c, err
 	}
 
-	_, err = moveCgroups(c)
+	err = pullImage(c)
+	if err != nil {
+		return c, err
+	}
+
+	err = pinDigest(c)
+	if err != nil {
+		return c, err
+	}
+
+	err = startContainer(c)
+	if err != nil {
+		return c, err
+	}
+
+	err = relaySockets(c)
 	if err != nil {
 		return c, err
 	}
@@ -550,7 +914,21 @@ func mainWithArgs(args []string) (*Context, error) {
 		return c, err
 	}
 
-	go pipeLogs(c)
+	checkLogDriver(c)
+
+	if len(c.LogFile) > 0 && !logsReadable(c.LogDriver) {
+		rmContainer(c)
+		return c, fmt.Errorf("Container %s uses log driver %s, --log-file can not read its logs", c.Id, c.LogDriver)
+	}
+
+	go func() {
+		err := pipeLogs(c)
+		if err != nil {
+			log.Println("Failed to pipe logs:", err)
+		}
+	}()
+
+	go tailFiles(c)
 
 	err = keepAlive(c)
 	if err != nil {
@@ -566,7 +944,20 @@ func mainWithArgs(args []string) (*Context, error) {
 }
 
 func main() {
-	_, err := mainWithArgs(os.Args[1:])
+	var err error
+	switch {
+	case len(os.Args) > 1 && os.Args[1] == "reload":
+		_, err = reloadWithArgs(os.Args[2:])
+	case len(os.Args) > 1 && os.Args[1] == "stop":
+		_, err = stopWithArgs(os.Args[2:])
+	default:
+		_, err = mainWithArgs(os.Args[1:])
+	}
+
+	if e, ok := err.(*exitError); ok {
+		log.Println(e)
+		os.Exit(e.Code)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
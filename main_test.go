@@ -72,14 +72,15 @@ func TestParseNotify(t *testing.T) {
 }
 
 func TestParseArgs(t *testing.T) {
-	c, err := parseContext([]string{"--logs=false", "run", "c", "-rm", "d"})
+	c, err := parseContext([]string{"--logs=false", "run", "-rm", "c", "-rm", "d"})
 	if err != nil {
 		t.Fatal("failed to parse:", err)
 	}
 
 	if c.Args[0] != "-d" ||
 		c.Args[1] != "c" ||
-		c.Args[2] != "d" {
+		c.Args[2] != "-rm" ||
+		c.Args[3] != "d" {
 		t.Fatal("Invalid args", c.Args)
 	}
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+var STATE_DIR string = "/run/systemd-docker"
+
+/*
+ * Docker can't change the labels of an existing container, so when a
+ * container is adopted with --adopt the new owner is recorded under
+ * STATE_DIR/owners/<container id> instead.
+ */
+func ownerRecord(id string) string {
+	return path.Join(STATE_DIR, "owners", id)
+}
+
+/* containerOwner returns the unit and machine id that own the container */
+func containerOwner(c *Context, id string) (string, string, error) {
+	if bytes, err := ioutil.ReadFile(ownerRecord(id)); err == nil {
+		parts := strings.SplitN(strings.TrimSpace(string(bytes)), "\n", 2)
+		if len(parts) == 2 {
+			return parts[0], parts[1], nil
+		}
+	}
+
+	labels, err := inspectLabels(id)
+	if err != nil {
+		return "", "", err
+	}
+
+	return labels[LABEL_UNIT], labels[LABEL_MACHINE_ID], nil
+}
+
+func recordOwner(c *Context, id string) error {
+	record := ownerRecord(id)
+	if err := os.MkdirAll(path.Dir(record), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(record, []byte(fmt.Sprintf("%s\n%s\n", c.Unit, c.MachineId)), 0644)
+}
+
+/*
+ * checkOwner makes sure a running container with our name was started by
+ * this unit on this machine before we take over its pid.  A container
+ * without labels was started by hand or by an older systemd-docker, which
+ * needs --adopt too.
+ */
+func checkOwner(c *Context, id string) error {
+	if !c.Labels {
+		return nil
+	}
+
+	unit, machineId, err := containerOwner(c, id)
+	if err != nil {
+		return err
+	}
+
+	if unit == c.Unit && machineId == c.MachineId {
+		return nil
+	}
+
+	owner := fmt.Sprintf("unit %q on machine %q", unit, machineId)
+	if len(unit) == 0 && len(machineId) == 0 {
+		owner = "nothing (it was not started by systemd-docker)"
+	}
+
+	if !c.Adopt {
+		return fmt.Errorf("Container %s is already running and is owned by %s, not unit %q on this machine.  Use --adopt to take it over", c.Name, owner, c.Unit)
+	}
+
+	log.Printf("Adopting container %s owned by %s\n", c.Name, owner)
+	return recordOwner(c, id)
+}
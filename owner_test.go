@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func withStateDir(t *testing.T, f func()) {
+	dir, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := STATE_DIR
+	STATE_DIR = dir
+	defer func() { STATE_DIR = old }()
+
+	f()
+}
+
+func TestCheckOwner(t *testing.T) {
+	withStateDir(t, func() {
+		owner := &Context{Name: "app", Labels: true, Unit: "app.service", MachineId: "m1"}
+		if err := recordOwner(owner, "abc"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := checkOwner(owner, "abc"); err != nil {
+			t.Fatal("owner should be allowed", err)
+		}
+
+		other := &Context{Name: "app", Labels: true, Unit: "other.service", MachineId: "m1"}
+		if err := checkOwner(other, "abc"); err == nil {
+			t.Fatal("another unit should be refused")
+		}
+
+		other.Adopt = true
+		if err := checkOwner(other, "abc"); err != nil {
+			t.Fatal("--adopt should take over", err)
+		}
+
+		if err := checkOwner(owner, "abc"); err == nil {
+			t.Fatal("adopted container now belongs to the other unit")
+		}
+	})
+}
+
+func TestCheckOwnerUnlabeled(t *testing.T) {
+	_, done := fakeDocker(t, map[string]http.HandlerFunc{
+		"/containers/abc/json": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"Id":"abc","Config":{"Labels":null}}`))
+		},
+		"/containers/abc": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		},
+	})
+	defer done()
+
+	withStateDir(t, func() {
+		c := &Context{Name: "app", Labels: true, Unit: "app.service", MachineId: "m1"}
+		if err := checkOwner(c, "abc"); err == nil {
+			t.Fatal("a container started by hand should need --adopt")
+		}
+
+		c.Adopt = true
+		if err := checkOwner(c, "abc"); err != nil {
+			t.Fatal("unlabeled container should be adopted with --adopt", err)
+		}
+
+		other := &Context{Name: "app", Labels: true, Unit: "other.service", MachineId: "m1"}
+		if err := checkOwner(other, "abc"); err == nil {
+			t.Fatal("adopted container now belongs to the unit")
+		}
+
+		if err := removeContainer(c, "abc", false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(ownerRecord("abc")); !os.IsNotExist(err) {
+			t.Fatal("owner record should be removed with the container", err)
+		}
+	})
+}
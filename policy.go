@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+/* Exit status when the host policy forbids the unit, LSB's "program is not configured" */
+const EXIT_POLICY = 6
+
+var POLICY_FILE string = "/etc/systemd-docker/policy.json"
+
+/*
+ * policyRule matches a docker run flag by its long name, like privileged or
+ * network, and optionally its value.  In values * matches anything, / too.
+ */
+type policyRule struct {
+	Flag  string `json:"flag"`
+	Value string `json:"value"`
+}
+
+/*
+ * hostPolicy is the host wide policy the administrator puts in POLICY_FILE
+ * to restrict what units may run.  No file means no restrictions.  A flag
+ * matching a deny rule is refused unless it also matches an allow rule or
+ * one of the exceptions for the unit.
+ */
+type hostPolicy struct {
+	Images     []string                `json:"images"`
+	Deny       []policyRule            `json:"deny"`
+	Allow      []policyRule            `json:"allow"`
+	Exceptions map[string][]policyRule `json:"exceptions"`
+}
+
+func loadPolicy() (*hostPolicy, error) {
+	data, err := ioutil.ReadFile(POLICY_FILE)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p := &hostPolicy{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", POLICY_FILE, err)
+	}
+
+	rules := append(append([]policyRule{}, p.Deny...), p.Allow...)
+	for _, exceptions := range p.Exceptions {
+		rules = append(rules, exceptions...)
+	}
+	for _, rule := range rules {
+		if len(rule.Flag) == 0 {
+			return nil, fmt.Errorf("Rule without a flag in %s", POLICY_FILE)
+		}
+	}
+
+	return p, nil
+}
+
+/* matchRepository matches a full repository name, a trailing /** matches everything below it */
+func matchRepository(pattern, repository string) bool {
+	if strings.HasSuffix(pattern, "/**") {
+		return strings.HasPrefix(repository, strings.TrimSuffix(pattern, "**"))
+	}
+	ok, _ := path.Match(pattern, repository)
+	return ok
+}
+
+/* resolvePath resolves the symlinks in as much of file as exists, docker creates the rest */
+func resolvePath(file string) string {
+	file = path.Clean(file)
+	rest := ""
+	for {
+		if resolved, err := filepath.EvalSymlinks(file); err == nil {
+			return path.Join(resolved, rest)
+		}
+		if file == "/" || file == "." {
+			return path.Join(file, rest)
+		}
+		rest = path.Join(path.Base(file), rest)
+		file = path.Dir(file)
+	}
+}
+
+/* ruleSource is the host path a volume rule is about, or "" if it is a pattern */
+func (r policyRule) ruleSource() string {
+	if canonicalFlag(r.Flag) != "volume" {
+		return ""
+	}
+
+	source := strings.SplitN(r.Value, ":", 2)[0]
+	if !path.IsAbs(source) || strings.Contains(source, "*") {
+		return ""
+	}
+	return resolvePath(source)
+}
+
+func (r policyRule) regexp() *regexp.Regexp {
+	value := r.Value
+	if source := r.ruleSource(); len(source) > 0 {
+		value = source + strings.TrimPrefix(value, strings.SplitN(value, ":", 2)[0])
+	}
+
+	pattern := strings.Replace(regexp.QuoteMeta(value), `\*`, `.*`, -1)
+	return regexp.MustCompile("^" + pattern + "$")
+}
+
+/*
+ * policyFlags is what rules match against.  Bind mounts from -v and
+ * --mount type=bind are both volume flags, with symlinks in the source
+ * resolved so /var/run/docker.sock and /run/docker.sock are the same thing.
+ */
+func policyFlags(f runFlag) []runFlag {
+	ret := []runFlag{f}
+
+	if bind, ok := parseBindMount(f); ok {
+		bind.Source = resolvePath(bind.Source)
+		volume := runFlag{Name: "volume", Value: bind.Volume(), HasValue: true, Args: f.Args}
+		if f.Name == "volume" {
+			ret[0] = volume
+		} else {
+			ret = append(ret, volume)
+		}
+	}
+
+	return ret
+}
+
+func policyValue(f runFlag) string {
+	if !RUN_VALUE_FLAGS[f.Name] {
+		if flagBool(f) {
+			return "true"
+		}
+		return "false"
+	}
+
+	return f.Value
+}
+
+func (r policyRule) match(f runFlag) bool {
+	if canonicalFlag(r.Flag) != f.Name {
+		return false
+	}
+
+	value := policyValue(f)
+	if len(r.Value) == 0 {
+		return value != "false"
+	}
+
+	return r.regexp().MatchString(value)
+}
+
+/* matchParent is true if f bind mounts a directory above the path a volume rule is about */
+func (r policyRule) matchParent(f runFlag) bool {
+	denied := r.ruleSource()
+	if len(denied) == 0 || f.Name != "volume" || !path.IsAbs(f.Value) {
+		return false
+	}
+
+	source := strings.SplitN(f.Value, ":", 2)[0]
+	return source == "/" || underDir(denied, source)
+}
+
+func matchRules(rules []policyRule, f runFlag) (policyRule, bool) {
+	for _, rule := range rules {
+		if rule.match(f) {
+			return rule, true
+		}
+	}
+	return policyRule{}, false
+}
+
+/* denyRule finds the deny rule for f, mounting a parent directory of a denied path counts too */
+func (p *hostPolicy) denyRule(f runFlag) (policyRule, bool) {
+	if rule, ok := matchRules(p.Deny, f); ok {
+		return rule, true
+	}
+
+	for _, rule := range p.Deny {
+		if rule.matchParent(f) {
+			return rule, true
+		}
+	}
+	return policyRule{}, false
+}
+
+/* unitRules are the exceptions for the unit, keys can be globs like backup@*.service */
+func (p *hostPolicy) unitRules(unit string) []policyRule {
+	ret := []policyRule{}
+	for pattern, rules := range p.Exceptions {
+		if ok, _ := path.Match(pattern, unit); ok && len(unit) > 0 {
+			ret = append(ret, rules...)
+		}
+	}
+	return ret
+}
+
+func (p *hostPolicy) allowImage(image string) error {
+	if len(p.Images) == 0 {
+		return nil
+	}
+
+	repository := imageRepository(image)
+	for _, pattern := range p.Images {
+		if matchRepository(pattern, repository) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("image %s (%s) is not in the allowed images", image, repository)
+}
+
+/* violations lists every flag that is denied for the unit */
+func (p *hostPolicy) violations(unit string, run *runArgs) []string {
+	allow := append(p.unitRules(unit), p.Allow...)
+
+	ret := []string{}
+	for _, f := range run.Flags {
+		for _, pf := range policyFlags(f) {
+			rule, denied := p.denyRule(pf)
+			if !denied {
+				continue
+			}
+			if _, allowed := matchRules(allow, pf); allowed {
+				continue
+			}
+
+			denial := rule.Flag
+			if len(rule.Value) > 0 {
+				denial += "=" + rule.Value
+			}
+			ret = append(ret, fmt.Sprintf("%s is denied by rule %s", strings.Join(f.Args, " "), denial))
+			break
+		}
+	}
+
+	return ret
+}
+
+/* checkPolicy refuses to start anything the host policy doesn't allow */
+func checkPolicy(c *Context, run *runArgs) error {
+	p, err := loadPolicy()
+	if err != nil {
+		return &exitError{EXIT_POLICY, err}
+	}
+	if p == nil {
+		return nil
+	}
+
+	violations := p.violations(c.Unit, run)
+	if len(run.Image) > 0 {
+		if err := p.allowImage(run.Image); err != nil {
+			violations = append(violations, err.Error())
+		}
+	}
+
+	if len(violations) > 0 {
+		unit := c.Unit
+		if len(unit) == 0 {
+			unit = "this unit"
+		}
+		return &exitError{EXIT_POLICY, fmt.Errorf("%s forbids %s: %s", POLICY_FILE, unit, strings.Join(violations, "; "))}
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func withPolicy(t *testing.T, policy string, fn func()) {
+	dir, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := POLICY_FILE
+	POLICY_FILE = path.Join(dir, "policy.json")
+	defer func() { POLICY_FILE = old }()
+
+	if len(policy) > 0 {
+		if err := ioutil.WriteFile(POLICY_FILE, []byte(policy), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fn()
+}
+
+func TestImagePolicy(t *testing.T) {
+	withPolicy(t, `{"images": ["docker.io/library/*", "registry.example.com/team/**"]}`, func() {
+		for image, allowed := range map[string]bool{
+			"nginx":                              true,
+			"nginx:1.9":                          true,
+			"docker.io/library/redis":            true,
+			"docker.io/someone/nginx":            false,
+			"registry.example.com/team/app":      true,
+			"registry.example.com/team/sub/app":  true,
+			"registry.example.com/other/app":     false,
+			"registry.example.com.evil.com/team": false,
+		} {
+			err := checkPolicy(&Context{}, parseRunArgs([]string{image}))
+			if allowed != (err == nil) {
+				t.Fatal("wrong decision for", image, err)
+			}
+		}
+	})
+}
+
+func TestNoPolicy(t *testing.T) {
+	withPolicy(t, "", func() {
+		if err := checkPolicy(&Context{}, parseRunArgs([]string{"--privileged", "anything/at:all"})); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	withPolicy(t, "{not json", func() {
+		if e, ok := checkPolicy(&Context{}, parseRunArgs([]string{"nginx"})).(*exitError); !ok || e.Code != EXIT_POLICY {
+			t.Fatal("a broken policy should not allow everything")
+		}
+	})
+}
+
+const FLAG_POLICY = `{
+	"deny": [
+		{"flag": "privileged"},
+		{"flag": "pid", "value": "host"},
+		{"flag": "net", "value": "host"},
+		{"flag": "volume", "value": "/:*"},
+		{"flag": "volume", "value": "/var/run/docker.sock:*"},
+		{"flag": "cap-add"}
+	],
+	"allow": [
+		{"flag": "cap-add", "value": "NET_BIND_SERVICE"}
+	],
+	"exceptions": {
+		"monitor@*.service": [{"flag": "pid", "value": "host"}, {"flag": "volume", "value": "/:/host:ro"}]
+	}
+}`
+
+func TestFlagPolicy(t *testing.T) {
+	withPolicy(t, FLAG_POLICY, func() {
+		for _, test := range []struct {
+			unit    string
+			args    []string
+			allowed bool
+		}{
+			{"web.service", []string{"-p", "80:80", "-v", "/srv:/srv", "nginx"}, true},
+			{"web.service", []string{"--privileged", "nginx"}, false},
+			{"web.service", []string{"--privileged=false", "nginx"}, true},
+			{"web.service", []string{"--pid=host", "nginx"}, false},
+			{"web.service", []string{"--network", "host", "nginx"}, false},
+			{"web.service", []string{"--net=bridge", "nginx"}, true},
+			{"web.service", []string{"-v", "/:/host", "nginx"}, false},
+			{"web.service", []string{"-v", "/var/run/../run/docker.sock:/docker.sock", "nginx"}, false},
+			{"web.service", []string{"--cap-add", "SYS_ADMIN", "nginx"}, false},
+			{"web.service", []string{"--cap-add", "NET_BIND_SERVICE", "nginx"}, true},
+			{"web.service", []string{"nginx", "--privileged"}, true},
+			{"monitor@1.service", []string{"--pid", "host", "-v", "/:/host:ro", "node-exporter"}, true},
+			{"monitor@1.service", []string{"-v", "/:/host", "node-exporter"}, false},
+			{"", []string{"--pid", "host", "node-exporter"}, false},
+		} {
+			err := checkPolicy(&Context{Unit: test.unit}, parseRunArgs(test.args))
+			if test.allowed != (err == nil) {
+				t.Fatal("wrong decision for", test.unit, test.args, err)
+			}
+		}
+	})
+}
+
+func TestPolicyMessage(t *testing.T) {
+	withPolicy(t, FLAG_POLICY, func() {
+		err := checkPolicy(&Context{Unit: "web.service"}, parseRunArgs([]string{"--privileged", "-v", "/:/host", "nginx"}))
+		expected := POLICY_FILE + " forbids web.service: --privileged is denied by rule privileged; -v /:/host is denied by rule volume=/:*"
+		if err == nil || err.Error() != expected {
+			t.Fatal("bad message", err)
+		}
+	})
+}
+
+func TestPolicyBindMounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	/* dir/link is to dir/real what /var/run is to /run */
+	os.MkdirAll(path.Join(dir, "real"), 0755)
+	ioutil.WriteFile(path.Join(dir, "real/docker.sock"), []byte{}, 0644)
+	os.Symlink("real", path.Join(dir, "link"))
+
+	policy := `{"deny": [{"flag": "volume", "value": "` + dir + `/link/docker.sock:*"}]}`
+	withPolicy(t, policy, func() {
+		for _, test := range []struct {
+			args    []string
+			allowed bool
+		}{
+			{[]string{"-v", dir + "/link/docker.sock:/x", "nginx"}, false},
+			{[]string{"-v", dir + "/real/docker.sock:/x", "nginx"}, false},
+			{[]string{"-v", dir + "/real:/x", "nginx"}, false},
+			{[]string{"-v", dir + "/link:/x:ro", "nginx"}, false},
+			{[]string{"-v", "/:/host", "nginx"}, false},
+			{[]string{"--mount", "type=bind,source=" + dir + "/link,target=/x", "nginx"}, false},
+			{[]string{"--mount", "type=bind,src=" + dir + "/real/docker.sock,dst=/x,readonly", "nginx"}, false},
+			{[]string{"--mount", "type=volume,source=data,target=/x", "nginx"}, true},
+			{[]string{"-v", dir + "/real/other:/x", "nginx"}, true},
+			{[]string{"-v", dir + "/realer:/x", "nginx"}, true},
+			{[]string{"-v", "data:/x", "nginx"}, true},
+		} {
+			err := checkPolicy(&Context{Unit: "web.service"}, parseRunArgs(test.args))
+			if test.allowed != (err == nil) {
+				t.Fatal("wrong decision for", test.args, err)
+			}
+		}
+	})
+}
+
+func TestResolvePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	real, _ := filepath.EvalSymlinks(dir)
+	os.Symlink(real, path.Join(dir, "link"))
+
+	if resolved := resolvePath(path.Join(dir, "link/missing/../file")); resolved != path.Join(real, "file") {
+		t.Fatal("bad resolved path", resolved)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	dockerClient "github.com/fsouza/go-dockerclient"
+)
+
+/* Exit status when the image can't be pulled, LSB's "program is not installed" */
+const EXIT_IMAGE_UNAVAILABLE = 5
+
+var (
+	PULL_BACKOFF     time.Duration = time.Second
+	PULL_MAX_BACKOFF time.Duration = 30 * time.Second
+	STATUS_INTERVAL  time.Duration = time.Second
+)
+
+/* Registry errors that retrying won't fix */
+var PULL_PERMANENT_ERRORS = []string{
+	"not found",
+	"no such image",
+	"does not exist",
+	"manifest unknown",
+	"unauthorized",
+	"authentication required",
+	"denied",
+	"invalid reference",
+}
+
+/* splitImageName splits an image into the repository and tag PullImage wants, a digest stays with the repository */
+func splitImageName(image string) (string, string) {
+	if strings.Contains(image, "@") {
+		return image, ""
+	}
+
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return image, "latest"
+	}
+
+	return image[:i], image[i+1:]
+}
+
+func transientPullError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, permanent := range PULL_PERMANENT_ERRORS {
+		if strings.Contains(msg, permanent) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+ * pullProgress turns the pull output into something fit for the journal.
+ * Status lines are logged when they change and progress bars only go to
+ * systemd's STATUS=, at most once every STATUS_INTERVAL.
+ */
+type pullProgress struct {
+	c          *Context
+	image      string
+	now        func() time.Time
+	partial    []byte
+	lastLine   string
+	lastStatus time.Time
+}
+
+func newPullProgress(c *Context, image string) *pullProgress {
+	return &pullProgress{
+		c:     c,
+		image: image,
+		now:   time.Now,
+	}
+}
+
+func (p *pullProgress) Write(data []byte) (int, error) {
+	p.partial = append(p.partial, data...)
+	for {
+		i := bytes.IndexAny(p.partial, "\r\n")
+		if i < 0 {
+			return len(data), nil
+		}
+
+		line := strings.TrimSpace(string(p.partial[:i]))
+		final := p.partial[i] == '\n'
+		p.partial = p.partial[i+1:]
+
+		if len(line) > 0 {
+			p.line(line, final)
+		}
+	}
+}
+
+func (p *pullProgress) line(line string, final bool) {
+	if final && line != p.lastLine {
+		log.Printf("Pulling %s: %s", p.image, line)
+		p.lastLine = line
+	}
+
+	if now := p.now(); now.Sub(p.lastStatus) >= STATUS_INTERVAL {
+		p.lastStatus = now
+		sdNotify(p.c, fmt.Sprintf("STATUS=Pulling %s: %s", p.image, line))
+	}
+}
+
+func (p *pullProgress) Flush() error {
+	p.partial = nil
+	return nil
+}
+
+/* pullImage makes sure the image is there before the container is started, according to --pull */
+func pullImage(c *Context) error {
+	if len(c.Pull) == 0 || len(c.Image) == 0 {
+		return nil
+	}
+
+	client, err := getClient(c)
+	if err != nil {
+		return err
+	}
+
+	if c.Pull != "always" {
+		_, err := client.InspectImage(c.Image)
+		if err == nil {
+			return nil
+		}
+		if err != dockerClient.ErrNoSuchImage {
+			return err
+		}
+		if c.Pull == "never" {
+			return &exitError{EXIT_IMAGE_UNAVAILABLE, fmt.Errorf("Image %s is not available locally and --pull=never", c.Image)}
+		}
+	}
+
+	auth, err := registryAuth(c, c.Image)
+	if err != nil {
+		return err
+	}
+
+	repository, tag := splitImageName(c.Image)
+	progress := newPullProgress(c, c.Image)
+	backoff := PULL_BACKOFF
+
+	for attempt := 1; ; attempt++ {
+		sdNotify(c, "STATUS=Pulling "+c.Image)
+		err = client.PullImage(dockerClient.PullImageOptions{
+			Repository:   repository,
+			Tag:          tag,
+			OutputStream: progress,
+		}, auth)
+		progress.Flush()
+
+		if err == nil {
+			log.Println("Pulled", c.Image)
+			sdNotify(c, "STATUS=Pulled "+c.Image)
+			return nil
+		}
+
+		if !transientPullError(err) || attempt > c.PullRetries {
+			return &exitError{EXIT_IMAGE_UNAVAILABLE, fmt.Errorf("Failed to pull %s: %v", c.Image, err)}
+		}
+
+		log.Printf("Failed to pull %s, retrying in %v: %v", c.Image, backoff, err)
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > PULL_MAX_BACKOFF {
+			backoff = PULL_MAX_BACKOFF
+		}
+	}
+}
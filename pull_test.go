@@ -0,0 +1,165 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitImageName(t *testing.T) {
+	for image, expected := range map[string][2]string{
+		"nginx":                         {"nginx", "latest"},
+		"nginx:1.9":                     {"nginx", "1.9"},
+		"localhost:5000/app":            {"localhost:5000/app", "latest"},
+		"localhost:5000/app:v2":         {"localhost:5000/app", "v2"},
+		"nginx@sha256:0123456789abcdef": {"nginx@sha256:0123456789abcdef", ""},
+	} {
+		repository, tag := splitImageName(image)
+		if repository != expected[0] || tag != expected[1] {
+			t.Fatal("bad split of", image, repository, tag)
+		}
+	}
+}
+
+func TestTransientPullError(t *testing.T) {
+	if !transientPullError(&apiError{500, "Get https://registry-1.docker.io/v2/: net/http: TLS handshake timeout"}) {
+		t.Fatal("timeouts should be retried")
+	}
+	if transientPullError(&apiError{500, "Error: image library/nosuch not found"}) {
+		t.Fatal("a missing image should not be retried")
+	}
+	if transientPullError(&apiError{500, "unauthorized: authentication required"}) {
+		t.Fatal("auth errors should not be retried")
+	}
+}
+
+/* notifySocket listens like systemd would and returns everything sent to it */
+func notifySocket(t *testing.T) (string, func() []string) {
+	dir, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	socket := path.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return socket, func() []string {
+		defer os.RemoveAll(dir)
+		defer conn.Close()
+
+		ret := []string{}
+		buf := make([]byte, 4096)
+		for {
+			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, err := conn.Read(buf)
+			if err != nil {
+				return ret
+			}
+			ret = append(ret, string(buf[:n]))
+		}
+	}
+}
+
+func TestPullProgress(t *testing.T) {
+	socket, received := notifySocket(t)
+
+	now := time.Unix(0, 0)
+	p := newPullProgress(&Context{NotifySocket: socket}, "nginx")
+	p.now = func() time.Time { return now }
+
+	p.Write([]byte("Pulling from library/nginx\nDownloading [=>   ] 1 MB/10 MB\rDownloading\n"))
+	p.Write([]byte("Downloading [==>  ] 2 MB/10 MB\rDownl"))
+	now = now.Add(STATUS_INTERVAL)
+	p.Write([]byte("oading\n"))
+
+	if p.lastLine != "Downloading" {
+		t.Fatal("bad last line", p.lastLine)
+	}
+
+	expected := []string{
+		"STATUS=Pulling nginx: Pulling from library/nginx",
+		"STATUS=Pulling nginx: Downloading",
+	}
+	if status := received(); strings.Join(status, "|") != strings.Join(expected, "|") {
+		t.Fatal("bad status updates", status)
+	}
+}
+
+func TestPullImage(t *testing.T) {
+	old := PULL_BACKOFF
+	PULL_BACKOFF = time.Millisecond
+	defer func() { PULL_BACKOFF = old }()
+
+	pulls := 0
+	badPull := ""
+	client, done := fakeDocker(t, map[string]http.HandlerFunc{
+		"/images/nginx/json": func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "No such image: nginx", http.StatusNotFound)
+		},
+		"/images/create": func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("fromImage") != "nginx" || r.URL.Query().Get("tag") != "latest" {
+				badPull = r.URL.String()
+			}
+			pulls++
+			w.Header().Set("Content-Type", "application/json")
+			if pulls == 1 {
+				w.Write([]byte(`{"error":"net/http: TLS handshake timeout"}`))
+				return
+			}
+			w.Write([]byte(`{"status":"Pulling from library/nginx"}`))
+		},
+	})
+	defer done()
+
+	c := &Context{Client: client, Image: "nginx", Pull: "never"}
+	if e, ok := pullImage(c).(*exitError); !ok || e.Code != EXIT_IMAGE_UNAVAILABLE || pulls != 0 {
+		t.Fatal("never should fail without pulling", e, pulls)
+	}
+
+	c.Pull = "missing"
+	c.PullRetries = 0
+	if e, ok := pullImage(c).(*exitError); !ok || e.Code != EXIT_IMAGE_UNAVAILABLE || pulls != 1 {
+		t.Fatal("should give up after one attempt", e, pulls)
+	}
+
+	pulls = 0
+	c.PullRetries = 3
+	if err := pullImage(c); err != nil || pulls != 2 {
+		t.Fatal("should succeed on retry", err, pulls)
+	}
+
+	if len(badPull) > 0 {
+		t.Fatal("bad pull", badPull)
+	}
+}
+
+func TestPullImagePresent(t *testing.T) {
+	pulled := false
+	client, done := fakeDocker(t, map[string]http.HandlerFunc{
+		"/images/nginx/json": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"Id":"abc"}`))
+		},
+		"/images/create": func(w http.ResponseWriter, r *http.Request) {
+			pulled = true
+		},
+	})
+	defer done()
+
+	for _, pull := range []string{"", "missing", "never"} {
+		if err := pullImage(&Context{Client: client, Image: "nginx", Pull: pull}); err != nil {
+			t.Fatal(pull, err)
+		}
+	}
+
+	if pulled {
+		t.Fatal("image is already there")
+	}
+}
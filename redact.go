@@ -0,0 +1,145 @@
+package main
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const REDACTED = "********"
+
+var (
+	REDACT_NAMES = []string{"PASSWORD", "TOKEN", "SECRET", "KEY"}
+	ENV_ASSIGN   = regexp.MustCompile(`(^|[^\w-])([A-Za-z_]\w*)=([^\s\]]*)`)
+)
+
+/*
+ * redactor masks secrets before they are written anywhere.  The values of
+ * NAME=VALUE pairs (which is how -e flags show up in args and docker errors)
+ * are masked when NAME contains one of names, and anything matched by one
+ * of the custom patterns is masked as well.
+ */
+type redactor struct {
+	names    []string
+	patterns []*regexp.Regexp
+}
+
+func newRedactor(names []string, patterns []string) (*redactor, error) {
+	r := &redactor{}
+
+	for _, name := range append(REDACT_NAMES, names...) {
+		r.names = append(r.names, strings.ToUpper(name))
+	}
+
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		r.patterns = append(r.patterns, re)
+	}
+
+	return r, nil
+}
+
+func (r *redactor) sensitive(name string) bool {
+	name = strings.ToUpper(name)
+	for _, n := range r.names {
+		if strings.Contains(name, n) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+
+	s = ENV_ASSIGN.ReplaceAllStringFunc(s, func(match string) string {
+		parts := ENV_ASSIGN.FindStringSubmatch(match)
+		if len(parts[3]) == 0 || !r.sensitive(parts[2]) {
+			return match
+		}
+		return parts[1] + parts[2] + "=" + REDACTED
+	})
+
+	for _, re := range r.patterns {
+		s = redactPattern(re, s)
+	}
+
+	return s
+}
+
+/* redactPattern masks the capture groups of re, or the whole match if it has none */
+func redactPattern(re *regexp.Regexp, s string) string {
+	if re.NumSubexp() == 0 {
+		return re.ReplaceAllString(s, REDACTED)
+	}
+
+	result := []byte{}
+	last := 0
+	for _, match := range re.FindAllStringSubmatchIndex(s, -1) {
+		for i := 2; i < len(match); i += 2 {
+			if match[i] < last {
+				continue
+			}
+			result = append(result, s[last:match[i]]...)
+			result = append(result, REDACTED...)
+			last = match[i+1]
+		}
+	}
+
+	return string(append(result, s[last:]...))
+}
+
+type redactWriter struct {
+	Out  io.Writer
+	r    *redactor
+	lock sync.Mutex
+	buf  lineBuffer
+}
+
+func newRedactWriter(r *redactor, out io.Writer) io.Writer {
+	if r == nil {
+		return out
+	}
+
+	return &redactWriter{
+		Out: out,
+		r:   r,
+	}
+}
+
+func (w *redactWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if err := w.buf.lines(p, w.writeLine); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (w *redactWriter) writeLine(line []byte) error {
+	_, err := io.WriteString(w.Out, w.r.Redact(string(line)))
+	return err
+}
+
+func (w *redactWriter) Flush() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if err := w.buf.rest(w.writeLine); err != nil {
+		return err
+	}
+
+	if flusher, ok := w.Out.(logFlusher); ok {
+		return flusher.Flush()
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRedactEnv(t *testing.T) {
+	r, err := newRedactor(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := r.Redact("Args: [-e DB_PASSWORD=hunter2 -e LANG=C --env=api_token=abc]")
+	expected := "Args: [-e DB_PASSWORD=" + REDACTED + " -e LANG=C --env=api_token=" + REDACTED + "]"
+	if result != expected {
+		t.Fatalf("expected %q got %q", expected, result)
+	}
+}
+
+func TestRedactCustom(t *testing.T) {
+	r, err := newRedactor([]string{"dsn"}, []string{`Bearer (\S+)`, `\d{4}-\d{4}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := r.Redact("MY_DSN=postgres://x auth: Bearer abc.def card 1234-5678")
+	expected := "MY_DSN=" + REDACTED + " auth: Bearer " + REDACTED + " card " + REDACTED
+	if result != expected {
+		t.Fatalf("expected %q got %q", expected, result)
+	}
+}
+
+func TestRedactBadRegex(t *testing.T) {
+	_, err := parseContext([]string{"--redact-regex", "(", "run"})
+	if err == nil {
+		t.Fatal("parse should fail on a bad regex")
+	}
+}
+
+func TestRedactWriter(t *testing.T) {
+	r, _ := newRedactor(nil, nil)
+	out := &bytes.Buffer{}
+	w := newRedactWriter(r, out)
+
+	w.Write([]byte("SECRET=a"))
+	w.Write([]byte("bc\nno newline SECRET=x"))
+	w.(logFlusher).Flush()
+
+	expected := "SECRET=" + REDACTED + "\nno newline SECRET=" + REDACTED + "\n"
+	if out.String() != expected {
+		t.Fatalf("expected %q got %q", expected, out.String())
+	}
+}
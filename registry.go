@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"strings"
+
+	dockerClient "github.com/fsouza/go-dockerclient"
+)
+
+const (
+	DEFAULT_REGISTRY = "docker.io"
+	INDEX_SERVER     = "https://index.docker.io/v1/"
+)
+
+var CREDENTIAL_HELPER = "docker-credential-%s"
+
+/* registryConfig is the part of the docker client's config.json that holds credentials */
+type registryConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		Email         string `json:"email"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredHelpers map[string]string `json:"credHelpers"`
+	CredsStore  string            `json:"credsStore"`
+}
+
+/* registryConfigPath finds config.json the same way the docker client does */
+func registryConfigPath(c *Context) string {
+	if len(c.RegistryConfig) > 0 {
+		return c.RegistryConfig
+	}
+
+	if dir := os.Getenv("DOCKER_CONFIG"); len(dir) > 0 {
+		return path.Join(dir, "config.json")
+	}
+
+	home := os.Getenv("HOME")
+	if len(home) == 0 {
+		if u, err := user.Current(); err == nil {
+			home = u.HomeDir
+		}
+	}
+
+	return path.Join(home, ".docker", "config.json")
+}
+
+/* splitRegistry splits an image into the registry it is pulled from and the rest of the name */
+func splitRegistry(image string) (string, string) {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 1 || !(strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return DEFAULT_REGISTRY, image
+	}
+	return normalizeRegistry(parts[0]), parts[1]
+}
+
+func registryHost(image string) string {
+	host, _ := splitRegistry(image)
+	return host
+}
+
+/* normalizeRegistry turns config.json keys like https://index.docker.io/v1/ into a bare host */
+func normalizeRegistry(key string) string {
+	if i := strings.Index(key, "://"); i >= 0 {
+		key = key[i+3:]
+	}
+	key = strings.SplitN(key, "/", 2)[0]
+
+	switch key {
+	case "index.docker.io", "registry-1.docker.io":
+		return DEFAULT_REGISTRY
+	}
+	return key
+}
+
+func loadRegistryConfig(file string) (*registryConfig, error) {
+	config := &registryConfig{}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", file, err)
+	}
+
+	return config, nil
+}
+
+/* credentialHelper runs docker-credential-<helper> get, nil means it has nothing for the registry */
+func credentialHelper(helper string, host string) (*dockerClient.AuthConfiguration, error) {
+	server := host
+	if host == DEFAULT_REGISTRY {
+		server = INDEX_SERVER
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(fmt.Sprintf(CREDENTIAL_HELPER, helper), "get")
+	cmd.Stdin = strings.NewReader(server)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(msg, "credentials not found") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s: %v %s", cmd.Path, err, msg)
+	}
+
+	creds := struct {
+		Username string
+		Secret   string
+	}{}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return nil, fmt.Errorf("%s: %v", cmd.Path, err)
+	}
+
+	if creds.Username == "<token>" {
+		return nil, fmt.Errorf("%s returned an identity token, which this Docker API version can't use", cmd.Path)
+	}
+
+	return &dockerClient.AuthConfiguration{
+		Username: creds.Username,
+		Password: creds.Secret,
+	}, nil
+}
+
+/*
+ * registryAuth finds the credentials for pulling image.  Like the docker
+ * client, a credHelpers entry for the registry wins over credsStore, and
+ * the plain auths entries are used when neither has anything.  No
+ * credentials means an anonymous pull.
+ */
+func registryAuth(c *Context, image string) (dockerClient.AuthConfiguration, error) {
+	file := registryConfigPath(c)
+	host := registryHost(image)
+
+	config, err := loadRegistryConfig(file)
+	if err != nil {
+		return dockerClient.AuthConfiguration{}, err
+	}
+
+	helper := config.CredsStore
+	for key, value := range config.CredHelpers {
+		if normalizeRegistry(key) == host {
+			helper = value
+		}
+	}
+
+	if len(helper) > 0 {
+		auth, err := credentialHelper(helper, host)
+		if err != nil {
+			log.Println("Failed to get credentials for", host, err)
+		} else if auth != nil {
+			return *auth, nil
+		}
+	}
+
+	for key, entry := range config.Auths {
+		if normalizeRegistry(key) != host {
+			continue
+		}
+
+		if len(entry.IdentityToken) > 0 {
+			log.Println("Ignoring identity token for", host, "in", file, "which this Docker API version can't use")
+		}
+
+		if len(entry.Auth) == 0 {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return dockerClient.AuthConfiguration{}, fmt.Errorf("Invalid auth for %s in %s: %v", key, file, err)
+		}
+
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return dockerClient.AuthConfiguration{}, fmt.Errorf("Invalid auth for %s in %s", key, file)
+		}
+
+		return dockerClient.AuthConfiguration{
+			Username: parts[0],
+			Password: parts[1],
+			Email:    entry.Email,
+		}, nil
+	}
+
+	return dockerClient.AuthConfiguration{}, nil
+}
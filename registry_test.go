@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	dockerClient "github.com/fsouza/go-dockerclient"
+)
+
+func TestRegistryHost(t *testing.T) {
+	for image, expected := range map[string]string{
+		"nginx":                                    "docker.io",
+		"library/nginx:1.9":                        "docker.io",
+		"docker.io/library/nginx":                  "docker.io",
+		"index.docker.io/library/nginx":            "docker.io",
+		"localhost/app":                            "localhost",
+		"localhost:5000/app:v2":                    "localhost:5000",
+		"registry.example.com/team/app@sha256:abc": "registry.example.com",
+	} {
+		if host := registryHost(image); host != expected {
+			t.Fatal("bad registry for", image, host)
+		}
+	}
+}
+
+func withRegistryConfig(t *testing.T, config string, fn func(c *Context, dir string)) {
+	dir, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "config.json")
+	if err := ioutil.WriteFile(file, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fn(&Context{RegistryConfig: file}, dir)
+}
+
+func TestRegistryAuths(t *testing.T) {
+	basic := base64.StdEncoding.EncodeToString([]byte("user:pa:ss"))
+	withRegistryConfig(t, `{"auths": {
+		"https://index.docker.io/v1/": {"auth": "`+basic+`"},
+		"registry.example.com": {"auth": "`+basic+`", "email": "ops@example.com"}
+	}}`, func(c *Context, dir string) {
+		auth, err := registryAuth(c, "nginx")
+		if err != nil || auth.Username != "user" || auth.Password != "pa:ss" {
+			t.Fatal("bad docker.io auth", auth, err)
+		}
+
+		auth, err = registryAuth(c, "registry.example.com/app")
+		if err != nil || auth.Email != "ops@example.com" {
+			t.Fatal("bad registry.example.com auth", auth, err)
+		}
+
+		auth, err = registryAuth(c, "quay.io/app")
+		if err != nil || auth != (dockerClient.AuthConfiguration{}) {
+			t.Fatal("quay.io should be anonymous", auth, err)
+		}
+	})
+}
+
+func TestRegistryCredentialHelpers(t *testing.T) {
+	basic := base64.StdEncoding.EncodeToString([]byte("plain:text"))
+	withRegistryConfig(t, `{
+		"auths": {"registry.example.com": {"auth": "`+basic+`"}, "quay.io": {"auth": "`+basic+`"}},
+		"credHelpers": {"registry.example.com": "helper"},
+		"credsStore": "store"
+	}`, func(c *Context, dir string) {
+		old := CREDENTIAL_HELPER
+		CREDENTIAL_HELPER = path.Join(dir, "docker-credential-%s")
+		defer func() { CREDENTIAL_HELPER = old }()
+
+		ioutil.WriteFile(path.Join(dir, "docker-credential-helper"), []byte(`#!/bin/sh
+read server
+echo "{\"ServerURL\":\"$server\",\"Username\":\"helper\",\"Secret\":\"$server\"}"
+`), 0755)
+		ioutil.WriteFile(path.Join(dir, "docker-credential-store"), []byte(`#!/bin/sh
+echo "credentials not found in native keychain"
+exit 1
+`), 0755)
+
+		auth, err := registryAuth(c, "registry.example.com/app")
+		if err != nil || auth.Username != "helper" || auth.Password != "registry.example.com" {
+			t.Fatal("credHelpers should win", auth, err)
+		}
+
+		auth, err = registryAuth(c, "quay.io/app")
+		if err != nil || auth.Username != "plain" {
+			t.Fatal("should fall back to auths when the store has nothing", auth, err)
+		}
+	})
+}
+
+func TestRegistryConfigPath(t *testing.T) {
+	old := os.Getenv("DOCKER_CONFIG")
+	defer os.Setenv("DOCKER_CONFIG", old)
+
+	os.Setenv("DOCKER_CONFIG", "/etc/docker-client")
+	if file := registryConfigPath(&Context{}); file != "/etc/docker-client/config.json" {
+		t.Fatal("DOCKER_CONFIG should be used", file)
+	}
+
+	if file := registryConfigPath(&Context{RegistryConfig: "/x.json"}); file != "/x.json" {
+		t.Fatal("--registry-config should win", file)
+	}
+}
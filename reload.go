@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	flag "github.com/docker/docker/pkg/mflag"
+	dockerClient "github.com/fsouza/go-dockerclient"
+)
+
+/*
+ * findContainer finds the container for a name, id or unit.  A unit's
+ * container is looked up by its auto name and then by the unit label.
+ * No target means the unit we run in, which is what ExecReload= and
+ * ExecStop= want.  Returns "" if there is no such container.
+ */
+func findContainer(c *Context, target string, all bool) (string, error) {
+	if len(target) == 0 {
+		target = getUnitName()
+		if len(target) == 0 {
+			return "", errors.New("no container given and not running in a systemd unit")
+		}
+	}
+
+	client, err := getClient(c)
+	if err != nil {
+		return "", err
+	}
+
+	names := []string{target}
+	isUnit := strings.HasSuffix(target, ".service")
+	if isUnit && containerName(target) != target {
+		names = append(names, containerName(target))
+	}
+
+	for _, name := range names {
+		container, err := client.InspectContainer(name)
+		if err == nil {
+			return container.ID, nil
+		}
+		if _, ok := err.(*dockerClient.NoSuchContainer); !ok {
+			return "", err
+		}
+	}
+
+	if !isUnit {
+		return "", nil
+	}
+
+	filters, err := json.Marshal(map[string][]string{
+		"label": {LABEL_UNIT + "=" + target},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	query := "filters=" + url.QueryEscape(string(filters))
+	if all {
+		query += "&all=1"
+	}
+
+	containers := []struct {
+		Id string
+	}{}
+	if err := dockerRequest("GET", "/containers/json?"+query, nil, &containers); err != nil {
+		return "", err
+	}
+
+	if len(containers) == 0 {
+		return "", nil
+	}
+	if len(containers) > 1 {
+		log.Println("Found", len(containers), "containers for", target, "using", containers[0].Id)
+	}
+
+	return containers[0].Id, nil
+}
+
+/* timedOut tells whether err is an API request giving up after its timeout */
+func timedOut(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+/* execContainer is docker exec, which the vendored client doesn't have.  The command may run for up to timeout */
+func execContainer(c *Context, id string, command []string, timeout time.Duration) error {
+	created := struct {
+		Id string
+	}{}
+
+	err := dockerRequest("POST", "/containers/"+id+"/exec", map[string]interface{}{
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Tty":          true,
+		"Cmd":          command,
+	}, &created)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]bool{
+		"Detach": false,
+		"Tty":    true,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := dockerDo("POST", "/exec/"+created.Id+"/start", bytes.NewReader(body), "application/json", timeout)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(newRedactWriter(c.Redactor, os.Stderr), resp.Body); err != nil {
+		return err
+	}
+
+	result := struct {
+		ExitCode int
+	}{}
+	if err := dockerRequest("GET", "/exec/"+created.Id+"/json", nil, &result); err != nil {
+		return err
+	}
+
+	if result.ExitCode != 0 {
+		return fmt.Errorf("%s exited with %d", strings.Join(command, " "), result.ExitCode)
+	}
+
+	return nil
+}
+
+/* splitReloadArgs splits what is left after the flags into the target and the command after -- */
+func splitReloadArgs(args []string, rest []string) (string, []string, error) {
+	target := rest
+	var command []string
+
+	for i, arg := range rest {
+		if arg == "--" {
+			target, command = rest[:i], rest[i+1:]
+			break
+		}
+	}
+
+	/* The flag parser swallows the -- when there is no target before it */
+	if command == nil && len(rest) > 0 {
+		for _, arg := range args[:len(args)-len(rest)] {
+			if arg == "--" {
+				target, command = nil, rest
+			}
+		}
+	}
+
+	if len(target) > 1 {
+		return "", nil, fmt.Errorf("expected one container or unit, got %s", strings.Join(target, " "))
+	}
+	if command != nil && len(command) == 0 {
+		return "", nil, errors.New("no command after --")
+	}
+
+	if len(target) == 0 {
+		return "", command, nil
+	}
+	return target[0], command, nil
+}
+
+/*
+ * reloadWithArgs is systemd-docker reload [NAME] [-- COMMAND...] for
+ * ExecReload=.  It sends the container a signal, or runs COMMAND in it,
+ * and tells systemd when the reload starts and ends.
+ */
+func reloadWithArgs(args []string) (*Context, error) {
+	c := &Context{
+		NotifySocket: os.Getenv("NOTIFY_SOCKET"),
+	}
+
+	flags := flag.NewFlagSet("systemd-docker reload", flag.ContinueOnError)
+	signal := flags.String([]string{"s", "-signal"}, "HUP", "signal to send to the container")
+	timeout := flags.Duration([]string{"t", "-timeout"}, 30*time.Second, "how long the reload may take")
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	var err error
+	c.Redactor, err = newRedactor(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	target, command, err := splitReloadArgs(args, flags.Args())
+	if err != nil {
+		return nil, err
+	}
+
+	c.Id, err = findContainer(c, target, false)
+	if err != nil {
+		return c, err
+	}
+	if len(c.Id) == 0 {
+		return c, fmt.Errorf("No running container for %s", target)
+	}
+
+	sdNotify(c, "RELOADING=1")
+
+	if len(command) > 0 {
+		log.Println("Reloading", c.Id, "with", strings.Join(command, " "))
+		err = execContainer(c, c.Id, command, *timeout)
+	} else {
+		log.Printf("Reloading %s with SIG%s", c.Id, strings.TrimPrefix(*signal, "SIG"))
+		err = dockerRequestTimeout("POST", "/containers/"+c.Id+"/kill?signal="+url.QueryEscape(*signal), nil, nil, *timeout)
+	}
+
+	if timedOut(err) {
+		err = fmt.Errorf("reload timed out after %v", *timeout)
+	}
+
+	if err != nil {
+		/*
+		 * systemd would wait for READY=1 until it gives up on the reload, our
+		 * exit code already tells it the reload failed
+		 */
+		sdNotify(c, "READY=1\nSTATUS=Reload failed: "+strings.Replace(err.Error(), "\n", " ", -1))
+		return c, err
+	}
+
+	sdNotify(c, "READY=1")
+	return c, nil
+}
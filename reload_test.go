@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitReloadArgs(t *testing.T) {
+	for _, test := range []struct {
+		args    []string
+		rest    []string
+		target  string
+		command []string
+	}{
+		{[]string{"web.service"}, []string{"web.service"}, "web.service", nil},
+		{[]string{"-s", "USR1"}, []string{}, "", nil},
+		{[]string{"web", "--", "nginx", "-s", "reload"}, []string{"web", "--", "nginx", "-s", "reload"}, "web", []string{"nginx", "-s", "reload"}},
+		{[]string{"-t", "5s", "--", "nginx", "-s", "reload"}, []string{"nginx", "-s", "reload"}, "", []string{"nginx", "-s", "reload"}},
+	} {
+		target, command, err := splitReloadArgs(test.args, test.rest)
+		if err != nil || target != test.target || !reflect.DeepEqual(command, test.command) {
+			t.Fatal("bad split of", test.args, target, command, err)
+		}
+	}
+
+	if _, _, err := splitReloadArgs([]string{"a", "b"}, []string{"a", "b"}); err == nil {
+		t.Fatal("two targets should fail")
+	}
+	if _, _, err := splitReloadArgs([]string{"a", "--"}, []string{"a", "--"}); err == nil {
+		t.Fatal("an empty command should fail")
+	}
+}
+
+func TestFindContainer(t *testing.T) {
+	client, done := fakeDocker(t, map[string]http.HandlerFunc{
+		"/containers/": func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/containers/web/json":
+				w.Write([]byte(`{"Id":"byname"}`))
+			case "/containers/app_1.service/json":
+				w.Write([]byte(`{"Id":"byautoname"}`))
+			case "/containers/json":
+				if !strings.Contains(r.URL.Query().Get("filters"), LABEL_UNIT+"=labelled.service") {
+					w.Write([]byte(`[]`))
+					return
+				}
+				if r.URL.Query().Get("all") != "1" {
+					w.Write([]byte(`[{"Id":"running"}]`))
+					return
+				}
+				w.Write([]byte(`[{"Id":"any"}]`))
+			default:
+				http.Error(w, "No such container", http.StatusNotFound)
+			}
+		},
+	})
+	defer done()
+
+	c := &Context{Client: client}
+	for _, test := range []struct {
+		target string
+		all    bool
+		id     string
+	}{
+		{"web", false, "byname"},
+		{"app@1.service", false, "byautoname"},
+		{"labelled.service", false, "running"},
+		{"labelled.service", true, "any"},
+		{"other.service", true, ""},
+		{"missing", false, ""},
+	} {
+		id, err := findContainer(c, test.target, test.all)
+		if err != nil || id != test.id {
+			t.Fatal("wrong container for", test.target, id, err)
+		}
+	}
+}
+
+func TestReloadSignal(t *testing.T) {
+	socket, received := notifySocket(t)
+
+	signal := ""
+	_, done := fakeDocker(t, map[string]http.HandlerFunc{
+		"/containers/web/json": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"Id":"abc"}`))
+		},
+		"/containers/abc/kill": func(w http.ResponseWriter, r *http.Request) {
+			signal = r.URL.Query().Get("signal")
+			w.WriteHeader(http.StatusNoContent)
+		},
+	})
+	defer done()
+
+	old := os.Getenv("NOTIFY_SOCKET")
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Setenv("NOTIFY_SOCKET", old)
+
+	if _, err := reloadWithArgs([]string{"--signal", "USR1", "web"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if signal != "USR1" {
+		t.Fatal("bad signal", signal)
+	}
+
+	if messages := received(); !reflect.DeepEqual(messages, []string{"RELOADING=1", "READY=1"}) {
+		t.Fatal("bad notifications", messages)
+	}
+}
+
+func TestReloadExec(t *testing.T) {
+	exitCode := "0"
+	var cmd []string
+	_, done := fakeDocker(t, map[string]http.HandlerFunc{
+		"/containers/web/json": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"Id":"abc"}`))
+		},
+		"/containers/abc/exec": func(w http.ResponseWriter, r *http.Request) {
+			body := struct{ Cmd []string }{}
+			json.NewDecoder(r.Body).Decode(&body)
+			cmd = body.Cmd
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"Id":"exec1"}`))
+		},
+		"/exec/exec1/start": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("reloaded\n"))
+		},
+		"/exec/exec1/json": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"ExitCode":` + exitCode + `}`))
+		},
+	})
+	defer done()
+
+	if _, err := reloadWithArgs([]string{"web", "--", "nginx", "-s", "reload"}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cmd, []string{"nginx", "-s", "reload"}) {
+		t.Fatal("bad command", cmd)
+	}
+
+	exitCode = "1"
+	if _, err := reloadWithArgs([]string{"web", "--", "nginx", "-s", "reload"}); err == nil {
+		t.Fatal("a failing command should fail the reload")
+	}
+}
+
+func TestReloadMissing(t *testing.T) {
+	_, done := fakeDocker(t, map[string]http.HandlerFunc{})
+	defer done()
+
+	if _, err := reloadWithArgs([]string{"web"}); err == nil {
+		t.Fatal("reloading a missing container should fail")
+	}
+}
+
+func TestReloadTimeout(t *testing.T) {
+	socket, received := notifySocket(t)
+
+	_, done := fakeDocker(t, map[string]http.HandlerFunc{
+		"/containers/web/json": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"Id":"abc"}`))
+		},
+		"/containers/abc/kill": func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(time.Second)
+			w.WriteHeader(http.StatusNoContent)
+		},
+	})
+	defer done()
+
+	old := os.Getenv("NOTIFY_SOCKET")
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Setenv("NOTIFY_SOCKET", old)
+
+	_, err := reloadWithArgs([]string{"-t", "10ms", "web"})
+	if err == nil || err.Error() != "reload timed out after 10ms" {
+		t.Fatal("should time out", err)
+	}
+
+	expected := []string{"RELOADING=1", "READY=1\nSTATUS=Reload failed: reload timed out after 10ms"}
+	if messages := received(); !reflect.DeepEqual(messages, expected) {
+		t.Fatal("a failed reload should be reported", messages)
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+/* docker run flags that take a value, by long name */
+var RUN_VALUE_FLAGS = map[string]bool{
+	"add-host":              true,
+	"annotation":            true,
+	"attach":                true,
+	"blkio-weight":          true,
+	"blkio-weight-device":   true,
+	"cap-add":               true,
+	"cap-drop":              true,
+	"cgroup-parent":         true,
+	"cgroupns":              true,
+	"cidfile":               true,
+	"cpu-count":             true,
+	"cpu-percent":           true,
+	"cpu-period":            true,
+	"cpu-quota":             true,
+	"cpu-rt-period":         true,
+	"cpu-rt-runtime":        true,
+	"cpu-shares":            true,
+	"cpus":                  true,
+	"cpuset":                true,
+	"cpuset-cpus":           true,
+	"cpuset-mems":           true,
+	"detach-keys":           true,
+	"device":                true,
+	"device-cgroup-rule":    true,
+	"device-read-bps":       true,
+	"device-read-iops":      true,
+	"device-write-bps":      true,
+	"device-write-iops":     true,
+	"dns":                   true,
+	"dns-option":            true,
+	"dns-search":            true,
+	"domainname":            true,
+	"entrypoint":            true,
+	"env":                   true,
+	"env-file":              true,
+	"expose":                true,
+	"gpus":                  true,
+	"group-add":             true,
+	"health-cmd":            true,
+	"health-interval":       true,
+	"health-retries":        true,
+	"health-start-interval": true,
+	"health-start-period":   true,
+	"health-timeout":        true,
+	"hostname":              true,
+	"io-maxbandwidth":       true,
+	"io-maxiops":            true,
+	"ip":                    true,
+	"ip6":                   true,
+	"ipc":                   true,
+	"isolation":             true,
+	"kernel-memory":         true,
+	"label":                 true,
+	"label-file":            true,
+	"link":                  true,
+	"link-local-ip":         true,
+	"log-driver":            true,
+	"log-opt":               true,
+	"lxc-conf":              true,
+	"mac-address":           true,
+	"memory":                true,
+	"memory-reservation":    true,
+	"memory-swap":           true,
+	"memory-swappiness":     true,
+	"mount":                 true,
+	"name":                  true,
+	"network":               true,
+	"network-alias":         true,
+	"oom-score-adj":         true,
+	"pid":                   true,
+	"pids-limit":            true,
+	"platform":              true,
+	"publish":               true,
+	"pull":                  true,
+	"restart":               true,
+	"runtime":               true,
+	"security-opt":          true,
+	"shm-size":              true,
+	"stop-signal":           true,
+	"stop-timeout":          true,
+	"storage-opt":           true,
+	"sysctl":                true,
+	"tmpfs":                 true,
+	"ulimit":                true,
+	"user":                  true,
+	"userns":                true,
+	"uts":                   true,
+	"volume":                true,
+	"volume-driver":         true,
+	"volumes-from":          true,
+	"workdir":               true,
+}
+
+/* docker run flags that don't take a value, anything unknown is treated the same */
+var RUN_BOOL_FLAGS = map[string]bool{
+	"detach":                true,
+	"disable-content-trust": true,
+	"help":                  true,
+	"init":                  true,
+	"interactive":           true,
+	"no-healthcheck":        true,
+	"oom-kill-disable":      true,
+	"privileged":            true,
+	"publish-all":           true,
+	"quiet":                 true,
+	"read-only":             true,
+	"rm":                    true,
+	"sig-proxy":             true,
+	"tty":                   true,
+}
+
+var RUN_SHORT_FLAGS = map[byte]string{
+	'a': "attach",
+	'c': "cpu-shares",
+	'd': "detach",
+	'e': "env",
+	'h': "hostname",
+	'i': "interactive",
+	'l': "label",
+	'm': "memory",
+	'p': "publish",
+	'P': "publish-all",
+	'q': "quiet",
+	't': "tty",
+	'u': "user",
+	'v': "volume",
+	'w': "workdir",
+}
+
+/* The long flags old docker accepted with a single dash, any other -abc is short flags */
+var RUN_LEGACY_FLAGS = map[string]bool{
+	"detach": true,
+	"name":   true,
+	"rm":     true,
+}
+
+var RUN_FLAG_ALIASES = map[string]string{
+	"net":       "network",
+	"net-alias": "network-alias",
+	"dns-opt":   "dns-option",
+}
+
+/* runFlag is one option given to docker run */
+type runFlag struct {
+	Name     string
+	Value    string
+	HasValue bool
+	Args     []string
+}
+
+/*
+ * runArgs is docker run's command line split into its options, the image
+ * and the command.  Everything after the image belongs to the container so
+ * it's never looked at.
+ */
+type runArgs struct {
+	Flags   []runFlag
+	Image   string
+	Command []string
+}
+
+func canonicalFlag(name string) string {
+	if alias, ok := RUN_FLAG_ALIASES[name]; ok {
+		return alias
+	}
+	return name
+}
+
+func parseRunArgs(args []string) *runArgs {
+	r := &runArgs{}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		switch {
+		case arg == "--":
+			if i+1 < len(args) {
+				r.Image = args[i+1]
+				r.Command = args[i+2:]
+			}
+			return r
+		case strings.HasPrefix(arg, "--"):
+			i = r.parseLong(args, i, arg[2:])
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			/* Old docker accepted long flags with a single dash, like -rm or -name */
+			if RUN_LEGACY_FLAGS[strings.SplitN(arg[1:], "=", 2)[0]] {
+				i = r.parseLong(args, i, arg[1:])
+			} else {
+				i = r.parseShort(args, i)
+			}
+		default:
+			r.Image = arg
+			r.Command = args[i+1:]
+			return r
+		}
+	}
+
+	return r
+}
+
+func (r *runArgs) parseLong(args []string, i int, flag string) int {
+	parts := strings.SplitN(flag, "=", 2)
+	f := runFlag{
+		Name: canonicalFlag(parts[0]),
+		Args: []string{args[i]},
+	}
+
+	if len(parts) == 2 {
+		f.Value = parts[1]
+		f.HasValue = true
+	} else if RUN_VALUE_FLAGS[f.Name] && i+1 < len(args) {
+		i++
+		f.Value = args[i]
+		f.HasValue = true
+		f.Args = append(f.Args, args[i])
+	}
+
+	r.Flags = append(r.Flags, f)
+	return i
+}
+
+/* parseShort handles -d, -d=false, -p 80:80, -p80:80 and combined flags like -dit or -dp 80:80 */
+func (r *runArgs) parseShort(args []string, i int) int {
+	arg := args[i]
+
+	/* Leave anything we don't understand alone for docker to complain about */
+	for j := 1; j < len(arg); j++ {
+		if arg[j] == '=' && j > 1 {
+			break
+		}
+		name, ok := RUN_SHORT_FLAGS[arg[j]]
+		if !ok {
+			r.Flags = append(r.Flags, runFlag{
+				Name: arg[1:],
+				Args: []string{arg},
+			})
+			return i
+		}
+		if RUN_VALUE_FLAGS[name] {
+			break
+		}
+	}
+
+	for j := 1; j < len(arg); j++ {
+		name := RUN_SHORT_FLAGS[arg[j]]
+
+		if !RUN_VALUE_FLAGS[name] && strings.HasPrefix(arg[j+1:], "=") {
+			r.Flags = append(r.Flags, runFlag{
+				Name:     name,
+				Value:    arg[j+2:],
+				HasValue: true,
+				Args:     []string{"-" + arg[j:]},
+			})
+			break
+		}
+
+		if !RUN_VALUE_FLAGS[name] {
+			r.Flags = append(r.Flags, runFlag{
+				Name: name,
+				Args: []string{"-" + arg[j:j+1]},
+			})
+			continue
+		}
+
+		f := runFlag{
+			Name:     name,
+			HasValue: true,
+		}
+
+		if value := strings.TrimPrefix(arg[j+1:], "="); len(arg[j+1:]) > 0 {
+			f.Value = value
+			f.Args = []string{"-" + arg[j:j+1], value}
+		} else if i+1 < len(args) {
+			i++
+			f.Value = args[i]
+			f.Args = []string{"-" + arg[j:j+1], args[i]}
+		} else {
+			f.HasValue = false
+			f.Args = []string{"-" + arg[j:j+1]}
+		}
+
+		r.Flags = append(r.Flags, f)
+		break
+	}
+
+	return i
+}
+
+/* Get returns the last value given for the flag */
+func (r *runArgs) Get(name string) (string, bool) {
+	value, found := "", false
+	for _, f := range r.Flags {
+		if f.Name == name {
+			value, found = f.Value, true
+		}
+	}
+	return value, found
+}
+
+/* GetAll returns every value given for a repeatable flag like --volume */
+func (r *runArgs) GetAll(name string) []string {
+	ret := []string{}
+	for _, f := range r.Flags {
+		if f.Name == name && f.HasValue {
+			ret = append(ret, f.Value)
+		}
+	}
+	return ret
+}
+
+/* Bool returns whether a boolean flag is set, handling --flag=false */
+func (r *runArgs) Bool(name string) bool {
+	set := false
+	for _, f := range r.Flags {
+		if f.Name == name {
+			set = !f.HasValue || f.Value != "false"
+		}
+	}
+	return set
+}
+
+func (r *runArgs) Remove(name string) {
+	flags := make([]runFlag, 0, len(r.Flags))
+	for _, f := range r.Flags {
+		if f.Name != name {
+			flags = append(flags, f)
+		}
+	}
+	r.Flags = flags
+}
+
+/* Args puts the command line back together */
+func (r *runArgs) Args() []string {
+	ret := []string{}
+	for _, f := range r.Flags {
+		ret = append(ret, f.Args...)
+	}
+
+	if len(r.Image) > 0 {
+		ret = append(ret, r.Image)
+		ret = append(ret, r.Command...)
+	}
+
+	return ret
+}
+
+/* bindMount is a host path mounted into the container with -v or --mount type=bind */
+type bindMount struct {
+	Source   string
+	Target   string
+	Options  string
+	ReadOnly bool
+}
+
+/* parseBindMount returns false for anything that isn't a bind mount of an absolute host path */
+func parseBindMount(f runFlag) (bindMount, bool) {
+	bind := bindMount{}
+
+	switch f.Name {
+	case "volume":
+		parts := strings.SplitN(f.Value, ":", 3)
+		if len(parts) < 2 || !path.IsAbs(parts[0]) {
+			return bind, false
+		}
+		bind.Source, bind.Target = parts[0], parts[1]
+		if len(parts) == 3 {
+			bind.Options = parts[2]
+			bind.ReadOnly = strings.Contains(","+parts[2]+",", ",ro,")
+		}
+	case "mount":
+		isBind := false
+		for _, field := range strings.Split(f.Value, ",") {
+			kv := strings.SplitN(field, "=", 2)
+			value := ""
+			if len(kv) == 2 {
+				value = kv[1]
+			}
+
+			switch kv[0] {
+			case "type":
+				isBind = value == "bind"
+			case "source", "src":
+				bind.Source = value
+			case "target", "destination", "dst":
+				bind.Target = value
+			case "readonly", "ro":
+				bind.ReadOnly = len(kv) == 1 || value == "true" || value == "1"
+			}
+		}
+		if !isBind || !path.IsAbs(bind.Source) {
+			return bind, false
+		}
+		if bind.ReadOnly {
+			bind.Options = "ro"
+		}
+	default:
+		return bind, false
+	}
+
+	bind.Source = path.Clean(bind.Source)
+	return bind, true
+}
+
+/* Volume is the bind mount in -v SOURCE:TARGET[:OPTIONS] form */
+func (b bindMount) Volume() string {
+	if len(b.Options) > 0 {
+		return b.Source + ":" + b.Target + ":" + b.Options
+	}
+	return b.Source + ":" + b.Target
+}
+
+/* BindMounts returns the host paths mounted with -v or --mount */
+func (r *runArgs) BindMounts() []bindMount {
+	ret := []bindMount{}
+	for _, f := range r.Flags {
+		if bind, ok := parseBindMount(f); ok {
+			ret = append(ret, bind)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRunArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		image   string
+		command []string
+		name    string
+		rm      bool
+		detach  bool
+		output  []string
+	}{
+		{
+			args:   []string{"nginx"},
+			image:  "nginx",
+			output: []string{"nginx"},
+		},
+		{
+			args:   []string{"--rm", "--name", "web", "nginx"},
+			image:  "nginx",
+			name:   "web",
+			rm:     true,
+			output: []string{"--rm", "--name", "web", "nginx"},
+		},
+		{
+			args:   []string{"--name=web", "nginx"},
+			image:  "nginx",
+			name:   "web",
+			output: []string{"--name=web", "nginx"},
+		},
+		{
+			args:   []string{"-name", "web", "-rm", "nginx"},
+			image:  "nginx",
+			name:   "web",
+			rm:     true,
+			output: []string{"-name", "web", "-rm", "nginx"},
+		},
+		{
+			args:   []string{"--name-something", "nginx"},
+			image:  "nginx",
+			output: []string{"--name-something", "nginx"},
+		},
+		{
+			args:    []string{"busybox", "--rm", "-d", "--name", "x"},
+			image:   "busybox",
+			command: []string{"--rm", "-d", "--name", "x"},
+			output:  []string{"busybox", "--rm", "-d", "--name", "x"},
+		},
+		{
+			args:   []string{"-e", "-d", "--label", "--rm", "nginx"},
+			image:  "nginx",
+			output: []string{"-e", "-d", "--label", "--rm", "nginx"},
+		},
+		{
+			args:   []string{"--rm=false", "nginx"},
+			image:  "nginx",
+			output: []string{"--rm=false", "nginx"},
+		},
+		{
+			args:   []string{"--rm=true", "--detach", "nginx"},
+			image:  "nginx",
+			rm:     true,
+			detach: true,
+			output: []string{"--rm=true", "--detach", "nginx"},
+		},
+		{
+			args:    []string{"-dit", "ubuntu", "bash"},
+			image:   "ubuntu",
+			command: []string{"bash"},
+			detach:  true,
+			output:  []string{"-d", "-i", "-t", "ubuntu", "bash"},
+		},
+		{
+			args:   []string{"-dp", "80:80", "nginx"},
+			image:  "nginx",
+			detach: true,
+			output: []string{"-d", "-p", "80:80", "nginx"},
+		},
+		{
+			args:   []string{"-ip", "80:80", "nginx"},
+			image:  "nginx",
+			output: []string{"-i", "-p", "80:80", "nginx"},
+		},
+		{
+			args:   []string{"-d=false", "nginx"},
+			image:  "nginx",
+			output: []string{"-d=false", "nginx"},
+		},
+		{
+			args:   []string{"-it=false", "-detach", "nginx"},
+			image:  "nginx",
+			detach: true,
+			output: []string{"-i", "-t=false", "-detach", "nginx"},
+		},
+		{
+			args:   []string{"-p80:80", "-p=443:443", "nginx"},
+			image:  "nginx",
+			output: []string{"-p", "80:80", "-p", "443:443", "nginx"},
+		},
+		{
+			args:   []string{"-v", "/a:/b", "--volume=/c:/d", "-w", "/b", "nginx"},
+			image:  "nginx",
+			output: []string{"-v", "/a:/b", "--volume=/c:/d", "-w", "/b", "nginx"},
+		},
+		{
+			args:   []string{"--net", "host", "--privileged", "nginx"},
+			image:  "nginx",
+			output: []string{"--net", "host", "--privileged", "nginx"},
+		},
+		{
+			args:   []string{"--unknown-flag", "nginx"},
+			image:  "nginx",
+			output: []string{"--unknown-flag", "nginx"},
+		},
+		{
+			args:   []string{"-xyz", "nginx"},
+			image:  "nginx",
+			output: []string{"-xyz", "nginx"},
+		},
+		{
+			args:    []string{"--", "nginx", "-g", "daemon off;"},
+			image:   "nginx",
+			command: []string{"-g", "daemon off;"},
+			output:  []string{"nginx", "-g", "daemon off;"},
+		},
+		{
+			args:   []string{"--name"},
+			output: []string{"--name"},
+		},
+		{
+			args:   []string{"-d", "--name"},
+			detach: true,
+			output: []string{"-d", "--name"},
+		},
+		{
+			args:    []string{"--entrypoint", "/bin/sh", "alpine", "-c", "echo --rm"},
+			image:   "alpine",
+			command: []string{"-c", "echo --rm"},
+			output:  []string{"--entrypoint", "/bin/sh", "alpine", "-c", "echo --rm"},
+		},
+		{
+			args:   []string{"--health-cmd", "curl -f localhost", "--restart", "always", "nginx"},
+			image:  "nginx",
+			output: []string{"--health-cmd", "curl -f localhost", "--restart", "always", "nginx"},
+		},
+		{
+			args:   []string{},
+			output: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		r := parseRunArgs(test.args)
+
+		if r.Image != test.image {
+			t.Fatalf("%v: expected image %q got %q", test.args, test.image, r.Image)
+		}
+
+		if len(test.command) > 0 && !reflect.DeepEqual(r.Command, test.command) {
+			t.Fatalf("%v: expected command %v got %v", test.args, test.command, r.Command)
+		}
+
+		if name, _ := r.Get("name"); name != test.name {
+			t.Fatalf("%v: expected name %q got %q", test.args, test.name, name)
+		}
+
+		if r.Bool("rm") != test.rm {
+			t.Fatalf("%v: expected rm %v", test.args, test.rm)
+		}
+
+		if r.Bool("detach") != test.detach {
+			t.Fatalf("%v: expected detach %v", test.args, test.detach)
+		}
+
+		if !reflect.DeepEqual(r.Args(), test.output) {
+			t.Fatalf("%v: expected args %v got %v", test.args, test.output, r.Args())
+		}
+	}
+}
+
+func TestRunArgsGetAll(t *testing.T) {
+	r := parseRunArgs([]string{"-e", "A=1", "--env=B=2", "-eC=3", "--dns", "1.1.1.1", "nginx", "-e", "D=4"})
+
+	if env := r.GetAll("env"); !reflect.DeepEqual(env, []string{"A=1", "B=2", "C=3"}) {
+		t.Fatal("bad env", env)
+	}
+}
+
+func TestRunArgsRemove(t *testing.T) {
+	r := parseRunArgs([]string{"--rm", "-d", "--name", "x", "-rm", "nginx", "--rm"})
+	r.Remove("rm")
+	r.Remove("detach")
+
+	if !reflect.DeepEqual(r.Args(), []string{"--name", "x", "nginx", "--rm"}) {
+		t.Fatal("bad args", r.Args())
+	}
+}
+
+func TestParseFlagValueNotMisread(t *testing.T) {
+	c, err := parseContext([]string{"run", "-e", "--rm", "--label", "-d", "nginx", "--rm"})
+	if err != nil {
+		t.Fatal("failed to parse:", err)
+	}
+
+	if c.Rm {
+		t.Fatal("--rm was a value and a container argument, not a flag")
+	}
+
+	if c.Image != "nginx" {
+		t.Fatal("bad image", c.Image)
+	}
+}
+
+func TestBindMounts(t *testing.T) {
+	run := parseRunArgs([]string{
+		"-v", "/srv/../data:/data:ro,z", "-v", "cache:/cache", "-v", "/tmp",
+		"--mount", "type=bind,source=/etc,target=/host/etc,readonly",
+		"--mount", "type=volume,source=x,target=/x", "nginx",
+	})
+
+	expected := []bindMount{
+		{Source: "/data", Target: "/data", Options: "ro,z", ReadOnly: true},
+		{Source: "/etc", Target: "/host/etc", Options: "ro", ReadOnly: true},
+	}
+	if mounts := run.BindMounts(); !reflect.DeepEqual(mounts, expected) {
+		t.Fatal("bad bind mounts", mounts)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const LISTEN_FDS_START = 3
+
+var (
+	SOCKET_DIAL_TIMEOUT time.Duration = 30 * time.Second
+	SOCKET_DIAL_RETRY   time.Duration = 100 * time.Millisecond
+)
+
+/*
+ * socketRelay accepts connections on a listener systemd passed us through
+ * socket activation and forwards each one to the container.  The listener
+ * stays open in systemd across restarts, so connections made while the
+ * container is restarting wait in the backlog instead of being refused.
+ */
+type socketRelay struct {
+	Name        string
+	Listener    net.Listener
+	Target      string
+	DialTimeout time.Duration
+}
+
+/* inheritedSockets returns the fds from LISTEN_FDS, if they are meant for us */
+func inheritedSockets() ([]*os.File, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %s", os.Getenv("LISTEN_FDS"))
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	files := []*os.File{}
+	for i := 0; i < count; i++ {
+		fd := LISTEN_FDS_START + i
+		/* docker run must not inherit them */
+		syscall.CloseOnExec(fd)
+
+		name := strconv.Itoa(fd)
+		if i < len(names) && len(names[i]) > 0 {
+			name = names[i]
+		}
+		files = append(files, os.NewFile(uintptr(fd), name))
+	}
+
+	return files, nil
+}
+
+/* parseSocketPorts parses --socket-port [NAME=]PORT, without a name the port is for every socket */
+func parseSocketPorts(ports []string) (map[string]int, error) {
+	ret := map[string]int{}
+	for _, value := range ports {
+		name, port := "", value
+		if i := strings.LastIndex(value, "="); i >= 0 {
+			name, port = value[:i], value[i+1:]
+		}
+
+		number, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || number == 0 {
+			return nil, fmt.Errorf("invalid socket port %s", value)
+		}
+		ret[name] = int(number)
+	}
+	return ret, nil
+}
+
+/* newSocketRelays pairs every inherited listener with the container port it forwards to */
+func newSocketRelays(files []*os.File, ports map[string]int, host string) ([]*socketRelay, error) {
+	relays := []*socketRelay{}
+
+	for _, file := range files {
+		listener, err := net.FileListener(file)
+		if err != nil {
+			return nil, fmt.Errorf("socket %s is not a stream listener, only those can be relayed: %v", file.Name(), err)
+		}
+		file.Close()
+
+		port, ok := ports[file.Name()]
+		if !ok {
+			port, ok = ports[""]
+		}
+		if addr, isTCP := listener.Addr().(*net.TCPAddr); !ok && isTCP {
+			port, ok = addr.Port, true
+		}
+		if !ok {
+			listener.Close()
+			return nil, fmt.Errorf("no container port for socket %s, use --socket-port %s=PORT", file.Name(), file.Name())
+		}
+
+		/* With --net=host the same port is systemd's listener, every connection would loop back to us */
+		if addr, isTCP := listener.Addr().(*net.TCPAddr); isTCP && addr.Port == port && net.ParseIP(host).IsLoopback() {
+			listener.Close()
+			return nil, fmt.Errorf("socket %s would be relayed to itself on port %d, use --socket-port %s=PORT with the port the container listens on", file.Name(), port, file.Name())
+		}
+
+		relays = append(relays, &socketRelay{
+			Name:        file.Name(),
+			Listener:    listener,
+			Target:      net.JoinHostPort(host, strconv.Itoa(port)),
+			DialTimeout: SOCKET_DIAL_TIMEOUT,
+		})
+	}
+
+	return relays, nil
+}
+
+/* dial connects to the container, waiting for it to start listening */
+func (r *socketRelay) dial() (net.Conn, error) {
+	deadline := time.Now().Add(r.DialTimeout)
+	for {
+		conn, err := net.Dial("tcp", r.Target)
+		if err == nil || time.Now().After(deadline) {
+			return conn, err
+		}
+		time.Sleep(SOCKET_DIAL_RETRY)
+	}
+}
+
+func (r *socketRelay) relay(client net.Conn) {
+	defer client.Close()
+
+	backend, err := r.dial()
+	if err != nil {
+		log.Println("Failed to relay connection on socket", r.Name, "to", r.Target, err)
+		return
+	}
+	defer backend.Close()
+
+	done := make(chan bool)
+	go func() {
+		io.Copy(backend, client)
+		if tcp, ok := backend.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+		done <- true
+	}()
+
+	io.Copy(client, backend)
+	if tcp, ok := client.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	}
+	<-done
+}
+
+func (r *socketRelay) serve() {
+	for {
+		conn, err := r.Listener.Accept()
+		if err != nil {
+			log.Println("Stopped relaying socket", r.Name, err)
+			return
+		}
+		go r.relay(conn)
+	}
+}
+
+/*
+ * containerAddress is where the container's ports can be reached from the
+ * host.  On the default bridge that is NetworkSettings.IPAddress, on a user
+ * defined network it is only in Networks, and with --net=host it is the
+ * host itself.
+ */
+func containerAddress(c *Context) (string, error) {
+	details, err := inspectDetails(c.Id)
+	if err != nil {
+		return "", err
+	}
+
+	if len(details.NetworkSettings.IPAddress) > 0 {
+		return details.NetworkSettings.IPAddress, nil
+	}
+
+	if details.HostConfig.NetworkMode == "host" {
+		return "127.0.0.1", nil
+	}
+
+	addresses := []string{}
+	for _, network := range details.NetworkSettings.Networks {
+		if len(network.IPAddress) > 0 {
+			addresses = append(addresses, network.IPAddress)
+		}
+	}
+	if len(addresses) == 1 {
+		return addresses[0], nil
+	}
+
+	return "", fmt.Errorf("Can not relay sockets, container %s on network %s has %d addresses", c.Id, details.HostConfig.NetworkMode, len(addresses))
+}
+
+/* relaySockets starts forwarding the socket activated listeners to the container */
+func relaySockets(c *Context) error {
+	if len(c.Sockets) == 0 {
+		return nil
+	}
+
+	host, err := containerAddress(c)
+	if err != nil {
+		return err
+	}
+
+	relays, err := newSocketRelays(c.Sockets, c.SocketPorts, host)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range relays {
+		log.Println("Relaying socket", r.Name, "to", r.Target)
+		go r.serve()
+	}
+
+	return nil
+}
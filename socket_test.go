@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseSocketPorts(t *testing.T) {
+	ports, err := parseSocketPorts([]string{"8080", "web=80", "a=b=443"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ports[""] != 8080 || ports["web"] != 80 || ports["a=b"] != 443 {
+		t.Fatal("bad ports", ports)
+	}
+
+	for _, port := range []string{"web", "web=", "web=0", "web=70000"} {
+		if _, err := parseSocketPorts([]string{port}); err == nil {
+			t.Fatal("should be invalid", port)
+		}
+	}
+}
+
+/* listenerFile makes a listener like the one systemd would pass us */
+func listenerFile(t *testing.T, name string) (*os.File, int) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	file, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return os.NewFile(uintptr(fd), name), l.Addr().(*net.TCPAddr).Port
+}
+
+func TestSocketRelay(t *testing.T) {
+	/* The container, an echo server */
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	file, port := listenerFile(t, "web")
+	relays, err := newSocketRelays([]*os.File{file}, map[string]int{"web": backend.Addr().(*net.TCPAddr).Port}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan bool)
+	go func() {
+		relays[0].serve()
+		served <- true
+	}()
+	defer func() {
+		relays[0].Listener.Close()
+		<-served
+	}()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte("hello\n"))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "hello\n" {
+		t.Fatal("bad echo", line, err)
+	}
+}
+
+func TestSocketRelayDefaultPort(t *testing.T) {
+	file, port := listenerFile(t, "unknown")
+	relays, err := newSocketRelays([]*os.File{file}, map[string]int{}, "172.17.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relays[0].Listener.Close()
+
+	if relays[0].Target != "172.17.0.2:"+strconv.Itoa(port) {
+		t.Fatal("should relay to the same port", relays[0].Target)
+	}
+}
+
+func TestSocketRelayHostNetwork(t *testing.T) {
+	file, port := listenerFile(t, "web")
+	if _, err := newSocketRelays([]*os.File{file}, map[string]int{}, "127.0.0.1"); err == nil {
+		t.Fatal("relaying to the listener's own port should fail")
+	}
+
+	file, _ = listenerFile(t, "web")
+	relays, err := newSocketRelays([]*os.File{file}, map[string]int{"web": port + 1}, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	relays[0].Listener.Close()
+}
+
+func TestSocketRelayWaitsForContainer(t *testing.T) {
+	/* Find a port nothing listens on yet */
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := l.Addr().String()
+	l.Close()
+
+	r := &socketRelay{Name: "web", Target: target, DialTimeout: 200 * time.Millisecond}
+	if _, err := r.dial(); err == nil {
+		t.Fatal("nothing is listening")
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		l, err := net.Listen("tcp", target)
+		if err == nil {
+			defer l.Close()
+			conn, _ := l.Accept()
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}()
+
+	conn, err := r.dial()
+	if err != nil {
+		t.Fatal("should connect once the container listens", err)
+	}
+	conn.Close()
+}
+
+func TestContainerAddress(t *testing.T) {
+	for inspect, expected := range map[string]string{
+		`{"NetworkSettings":{"IPAddress":"172.17.0.2"}}`:                                                       "172.17.0.2",
+		`{"HostConfig":{"NetworkMode":"host"},"NetworkSettings":{"IPAddress":""}}`:                             "127.0.0.1",
+		`{"HostConfig":{"NetworkMode":"app"},"NetworkSettings":{"Networks":{"app":{"IPAddress":"10.0.0.5"}}}}`: "10.0.0.5",
+		`{"HostConfig":{"NetworkMode":"none"},"NetworkSettings":{"IPAddress":""}}`:                             "",
+	} {
+		body := inspect
+		_, done := fakeDocker(t, map[string]http.HandlerFunc{
+			"/containers/abc/json": func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(body))
+			},
+		})
+
+		host, err := containerAddress(&Context{Id: "abc"})
+		done()
+
+		if len(expected) == 0 {
+			if err == nil {
+				t.Fatal("should fail without an address", inspect, host)
+			}
+		} else if err != nil || host != expected {
+			t.Fatal("bad address", inspect, host, err)
+		}
+	}
+}
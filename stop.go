@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	flag "github.com/docker/docker/pkg/mflag"
+	dockerClient "github.com/fsouza/go-dockerclient"
+)
+
+var (
+	SYSTEMCTL            string        = "systemctl"
+	STOP_DEFAULT_TIMEOUT time.Duration = 10 * time.Second
+	STOP_MARGIN          time.Duration = 5 * time.Second
+)
+
+var TIMESPAN = regexp.MustCompile(`^\s*(\d+(?:\.\d+)?)\s*([a-z]*)`)
+
+var TIMESPAN_UNITS = map[string]time.Duration{
+	"":        time.Second,
+	"us":      time.Microsecond,
+	"usec":    time.Microsecond,
+	"ms":      time.Millisecond,
+	"msec":    time.Millisecond,
+	"s":       time.Second,
+	"sec":     time.Second,
+	"second":  time.Second,
+	"seconds": time.Second,
+	"m":       time.Minute,
+	"min":     time.Minute,
+	"minute":  time.Minute,
+	"minutes": time.Minute,
+	"h":       time.Hour,
+	"hr":      time.Hour,
+	"hour":    time.Hour,
+	"hours":   time.Hour,
+	"d":       24 * time.Hour,
+	"day":     24 * time.Hour,
+	"days":    24 * time.Hour,
+	"w":       7 * 24 * time.Hour,
+	"week":    7 * 24 * time.Hour,
+	"weeks":   7 * 24 * time.Hour,
+}
+
+/* parseTimespan parses systemd time spans like "1min 30s", infinity is returned as 0 */
+func parseTimespan(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "infinity" {
+		return 0, nil
+	}
+
+	total := time.Duration(0)
+	rest := value
+	for len(strings.TrimSpace(rest)) > 0 {
+		match := TIMESPAN.FindStringSubmatch(rest)
+		if match == nil {
+			return 0, fmt.Errorf("invalid time span %s", value)
+		}
+
+		unit, ok := TIMESPAN_UNITS[match[2]]
+		if !ok {
+			return 0, fmt.Errorf("invalid time span %s", value)
+		}
+
+		number, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			return 0, err
+		}
+
+		total += time.Duration(number * float64(unit))
+		rest = rest[len(match[0]):]
+	}
+
+	return total, nil
+}
+
+/*
+ * stopTimeout is how long docker should wait before killing the container,
+ * a bit less than the unit's TimeoutStopSec= so that docker kills it
+ * before systemd gives up on us.
+ */
+func stopTimeout(unit string) time.Duration {
+	if len(unit) == 0 {
+		return STOP_DEFAULT_TIMEOUT
+	}
+
+	output, err := exec.Command(SYSTEMCTL, "show", "--property=TimeoutStopUSec", unit).Output()
+	if err != nil {
+		log.Println("Failed to get TimeoutStopSec of", unit, err)
+		return STOP_DEFAULT_TIMEOUT
+	}
+
+	value := strings.TrimPrefix(strings.TrimSpace(string(output)), "TimeoutStopUSec=")
+	timeout, err := parseTimespan(value)
+	if err != nil {
+		log.Println("Failed to get TimeoutStopSec of", unit, err)
+		return STOP_DEFAULT_TIMEOUT
+	}
+
+	if timeout == 0 {
+		return math.MaxInt32 * time.Second
+	}
+
+	timeout -= STOP_MARGIN
+	if timeout < time.Second {
+		timeout = time.Second
+	}
+	return timeout
+}
+
+/* gone is true for errors that mean the container doesn't exist anymore */
+func gone(err error) bool {
+	if _, ok := err.(*dockerClient.NoSuchContainer); ok {
+		return true
+	}
+	if e, ok := err.(*dockerClient.Error); ok && e.Status == 409 && strings.Contains(e.Message, "already in progress") {
+		return true
+	}
+	return false
+}
+
+/*
+ * stopWithArgs is systemd-docker stop [NAME] for ExecStop= and
+ * ExecStopPost=.  It stops the container through docker, and with --rm
+ * removes it.  A container that is already stopped or gone is not an error.
+ */
+func stopWithArgs(args []string) (*Context, error) {
+	c := &Context{}
+
+	flags := flag.NewFlagSet("systemd-docker stop", flag.ContinueOnError)
+	flTimeout := flags.String([]string{"t", "-timeout"}, "", "how long to wait before killing the container, defaults to a bit less than TimeoutStopSec=")
+	flags.BoolVar(&c.Rm, []string{"-rm"}, false, "remove the container once it is stopped")
+	removeVolumes := flags.Bool([]string{"v", "-volumes"}, false, "with --rm also remove the container's anonymous volumes")
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	target := ""
+	switch rest := flags.Args(); len(rest) {
+	case 0:
+	case 1:
+		target = rest[0]
+	default:
+		return nil, fmt.Errorf("expected one container or unit, got %s", strings.Join(rest, " "))
+	}
+
+	/* Not a Duration flag so that -t 0, kill right away, can be told from no -t */
+	var timeout time.Duration
+	if len(*flTimeout) > 0 {
+		var err error
+		timeout, err = time.ParseDuration(*flTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout %s", *flTimeout)
+		}
+	}
+
+	var err error
+	c.Id, err = findContainer(c, target, true)
+	if err != nil {
+		return c, err
+	}
+	if len(c.Id) == 0 {
+		log.Println("No container for", target, "nothing to stop")
+		return c, nil
+	}
+
+	if len(*flTimeout) == 0 {
+		unit := target
+		if !strings.HasSuffix(unit, ".service") {
+			unit = getUnitName()
+		}
+		timeout = stopTimeout(unit)
+	}
+
+	client, err := getClient(c)
+	if err != nil {
+		return c, err
+	}
+
+	log.Printf("Stopping %s, killing it after %v", c.Id, timeout)
+	err = client.StopContainer(c.Id, uint((timeout+time.Second-1)/time.Second))
+	if gone(err) {
+		log.Println("Container", c.Id, "is already gone")
+		return c, nil
+	}
+	if err != nil || !c.Rm {
+		return c, err
+	}
+
+	err = removeContainer(c, c.Id, *removeVolumes)
+	if gone(err) {
+		return c, nil
+	}
+	return c, err
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestParseTimespan(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"90":            90 * time.Second,
+		"1min 30s":      90 * time.Second,
+		"1min30s":       90 * time.Second,
+		"2h 5min":       2*time.Hour + 5*time.Minute,
+		"1.5s":          1500 * time.Millisecond,
+		"500ms":         500 * time.Millisecond,
+		"1d 1us":        24*time.Hour + time.Microsecond,
+		"infinity":      0,
+		" 10s\n":        10 * time.Second,
+		"3 weeks 1 day": 22 * 24 * time.Hour,
+	} {
+		timeout, err := parseTimespan(value)
+		if err != nil || timeout != expected {
+			t.Fatal("bad time span", value, timeout, err)
+		}
+	}
+
+	for _, value := range []string{"soon", "10 parsecs", "1min x"} {
+		if _, err := parseTimespan(value); err == nil {
+			t.Fatal("should be invalid", value)
+		}
+	}
+}
+
+func withSystemctl(t *testing.T, output string, fn func()) {
+	dir, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := SYSTEMCTL
+	SYSTEMCTL = path.Join(dir, "systemctl")
+	defer func() { SYSTEMCTL = old }()
+
+	ioutil.WriteFile(SYSTEMCTL, []byte("#!/bin/sh\necho '"+output+"'\n"), 0755)
+	fn()
+}
+
+func TestStopTimeout(t *testing.T) {
+	withSystemctl(t, "TimeoutStopUSec=1min 30s", func() {
+		if timeout := stopTimeout("web.service"); timeout != 85*time.Second {
+			t.Fatal("bad timeout", timeout)
+		}
+	})
+
+	withSystemctl(t, "TimeoutStopUSec=2s", func() {
+		if timeout := stopTimeout("web.service"); timeout != time.Second {
+			t.Fatal("timeout should be at least a second", timeout)
+		}
+	})
+
+	withSystemctl(t, "garbage", func() {
+		if timeout := stopTimeout("web.service"); timeout != STOP_DEFAULT_TIMEOUT {
+			t.Fatal("should fall back to the default", timeout)
+		}
+	})
+
+	if timeout := stopTimeout(""); timeout != STOP_DEFAULT_TIMEOUT {
+		t.Fatal("no unit should use the default", timeout)
+	}
+}
+
+func TestStop(t *testing.T) {
+	stopped, removed, method := "", "", ""
+	_, done := fakeDocker(t, map[string]http.HandlerFunc{
+		"/containers/web/json": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"Id":"abc"}`))
+		},
+		"/containers/abc/stop": func(w http.ResponseWriter, r *http.Request) {
+			stopped = r.URL.Query().Get("t")
+			w.WriteHeader(http.StatusNoContent)
+		},
+		"/containers/abc": func(w http.ResponseWriter, r *http.Request) {
+			method = r.Method
+			removed = r.URL.Query().Get("v")
+			w.WriteHeader(http.StatusNoContent)
+		},
+	})
+	defer done()
+
+	if _, err := stopWithArgs([]string{"-t", "20s", "web"}); err != nil {
+		t.Fatal(err)
+	}
+	if stopped != "20" || removed != "" {
+		t.Fatal("should only stop", stopped, removed)
+	}
+
+	if _, err := stopWithArgs([]string{"-t", "1500ms", "--rm", "-v", "web"}); err != nil {
+		t.Fatal(err)
+	}
+	if stopped != "2" || removed != "1" || method != "DELETE" {
+		t.Fatal("should stop and remove with volumes", stopped, removed)
+	}
+
+	withSystemctl(t, "TimeoutStopUSec=1min 30s", func() {
+		if _, err := stopWithArgs([]string{"--timeout", "0", "web"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if stopped != "0" {
+		t.Fatal("a zero timeout should kill right away", stopped)
+	}
+
+	if _, err := stopWithArgs([]string{"-t", "soon", "web"}); err == nil {
+		t.Fatal("an invalid timeout should fail")
+	}
+}
+
+func TestStopGone(t *testing.T) {
+	_, done := fakeDocker(t, map[string]http.HandlerFunc{
+		"/containers/web/json": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"Id":"abc"}`))
+		},
+		"/containers/abc/stop": func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "No such container: abc", http.StatusNotFound)
+		},
+	})
+	defer done()
+
+	/* Removed by the wrapper's --rm between the lookup and the stop */
+	if _, err := stopWithArgs([]string{"-t", "1s", "--rm", "web"}); err != nil {
+		t.Fatal(err)
+	}
+
+	/* Never there at all */
+	if _, err := stopWithArgs([]string{"-t", "1s", "missing"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStopAlreadyStopped(t *testing.T) {
+	removed := false
+	_, done := fakeDocker(t, map[string]http.HandlerFunc{
+		"/containers/web/json": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"Id":"abc"}`))
+		},
+		"/containers/abc/stop": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotModified)
+		},
+		"/containers/abc": func(w http.ResponseWriter, r *http.Request) {
+			removed = true
+			http.Error(w, "removal of container abc is already in progress", http.StatusConflict)
+		},
+	})
+	defer done()
+
+	if _, err := stopWithArgs([]string{"-t", "1s", "--rm", "web"}); err != nil || !removed {
+		t.Fatal("a stopped container should still be removed", err, removed)
+	}
+}
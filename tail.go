@@ -0,0 +1,284 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	CONTAINER_ROOT string        = "/proc/%d/root"
+	TAIL_INTERVAL  time.Duration = time.Second
+)
+
+/*
+ * fileTailer follows a single file inside the container.  Files are read
+ * through /proc/<pid>/root so it doesn't matter if the path is a bind mount
+ * or only exists in the container's filesystem.  They are opened with
+ * openInRoot so that symlinks can't point us at files on the host.
+ */
+type fileTailer struct {
+	root    string
+	path    string
+	tag     string
+	out     io.Writer
+	file    *os.File
+	offset  int64
+	fromEnd bool
+	data    []byte
+	buf     lineBuffer
+	lastErr string
+}
+
+func (t *fileTailer) writeLine(line []byte) error {
+	_, err := fmt.Fprintf(t.out, "%s: %s", t.tag, line)
+	return err
+}
+
+/* report logs err, but only once until the error changes so a missing file doesn't flood the journal */
+func (t *fileTailer) report(err error) {
+	if err == nil {
+		t.lastErr = ""
+		return
+	}
+
+	if err.Error() != t.lastErr {
+		t.lastErr = err.Error()
+		log.Println("Failed to read", t.path, err)
+	}
+}
+
+func (t *fileTailer) open(fromEnd bool) error {
+	file, err := openInRoot(t.root, t.path)
+	if err != nil {
+		return err
+	}
+
+	t.file = file
+	t.offset = 0
+	if fromEnd {
+		t.offset, err = file.Seek(0, os.SEEK_END)
+	}
+
+	return err
+}
+
+func (t *fileTailer) read() error {
+	if t.data == nil {
+		t.data = make([]byte, 32*1024)
+	}
+
+	for {
+		n, err := t.file.ReadAt(t.data, t.offset)
+		if n > 0 {
+			t.offset += int64(n)
+			if err := t.buf.lines(t.data[:n], t.writeLine); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+/* poll forwards new lines and deals with the file being rotated or truncated */
+func (t *fileTailer) poll() error {
+	if t.file == nil {
+		fromEnd := t.fromEnd
+		t.fromEnd = false
+		if err := t.open(fromEnd); err != nil {
+			return err
+		}
+		return t.read()
+	}
+
+	/* Open the path again to see if it is still the same file */
+	file, err := openInRoot(t.root, t.path)
+	if os.IsNotExist(err) {
+		return t.read()
+	}
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	current, err := t.file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if os.SameFile(info, current) {
+		file.Close()
+	} else {
+		/* Rotated, drain what was written before the rotation then switch */
+		if err := t.read(); err != nil {
+			file.Close()
+			return err
+		}
+		t.buf.rest(t.writeLine)
+		t.close()
+		t.file = file
+		t.offset = 0
+	}
+
+	if info.Size() < t.offset {
+		t.offset = 0
+	}
+
+	return t.read()
+}
+
+func (t *fileTailer) close() {
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
+
+/* pollTails finds files matching patterns under root and forwards anything new */
+func pollTails(root string, patterns []string, tailers map[string]*fileTailer, out io.Writer, fromEnd bool) {
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(path.Join(root, pattern))
+		if err != nil {
+			log.Println("Invalid tail file pattern", pattern, err)
+			continue
+		}
+
+		for _, match := range matches {
+			name := path.Join("/", strings.TrimPrefix(match, root))
+			if _, ok := tailers[name]; ok {
+				continue
+			}
+
+			tailers[name] = &fileTailer{
+				root:    root,
+				path:    name,
+				tag:     path.Base(name),
+				out:     out,
+				fromEnd: fromEnd,
+			}
+		}
+	}
+
+	for _, t := range tailers {
+		t.report(t.poll())
+	}
+}
+
+func tailFiles(c *Context) {
+	if len(c.TailFiles) == 0 {
+		return
+	}
+
+	root := fmt.Sprintf(CONTAINER_ROOT, c.Pid)
+	out := newLogStream(c, os.Stdout)
+	tailers := map[string]*fileTailer{}
+
+	fromEnd := true
+	for !pidDied(c.Pid) {
+		pollTails(root, c.TailFiles, tailers, out, fromEnd)
+		fromEnd = false
+		time.Sleep(TAIL_INTERVAL)
+	}
+
+	for _, t := range tailers {
+		t.buf.rest(t.writeLine)
+		t.close()
+	}
+
+	if flusher, ok := out.(logFlusher); ok {
+		flusher.Flush()
+	}
+}
+
+const (
+	SYS_OPENAT2     = 437
+	RESOLVE_IN_ROOT = 0x10
+)
+
+/* openHow is struct open_how from linux/openat2.h */
+type openHow struct {
+	Flags   uint64
+	Mode    uint64
+	Resolve uint64
+}
+
+/*
+ * openInRoot opens name as if root was /, the wrapper runs as root so an
+ * absolute symlink in the container must not be followed on the host.  It
+ * uses openat2 with RESOLVE_IN_ROOT, which resolves symlinks the way the
+ * container sees them.  Kernels before 5.6 don't have openat2, there no
+ * symlinks are followed at all.
+ */
+func openInRoot(root string, name string) (*os.File, error) {
+	dir, err := os.Open(root)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	p, err := syscall.BytePtrFromString(strings.TrimLeft(name, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	how := openHow{
+		Flags:   syscall.O_RDONLY | syscall.O_CLOEXEC,
+		Resolve: RESOLVE_IN_ROOT,
+	}
+	fd, _, errno := syscall.Syscall6(SYS_OPENAT2, dir.Fd(), uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&how)), unsafe.Sizeof(how), 0, 0)
+	switch errno {
+	case 0:
+		return os.NewFile(fd, name), nil
+	case syscall.ENOSYS:
+		return openNoFollow(int(dir.Fd()), name)
+	default:
+		return nil, &os.PathError{Op: "open", Path: name, Err: errno}
+	}
+}
+
+/* openNoFollow opens name under dir one component at a time, refusing any symlink */
+func openNoFollow(dir int, name string) (*os.File, error) {
+	fd, err := syscall.Dup(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/")
+	for i, part := range parts {
+		flags := syscall.O_RDONLY | syscall.O_CLOEXEC | syscall.O_NOFOLLOW
+		if i < len(parts)-1 {
+			flags |= syscall.O_DIRECTORY
+		}
+
+		next, err := syscall.Openat(fd, part, flags, 0)
+		syscall.Close(fd)
+		if err == syscall.ELOOP {
+			return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("refusing to follow a symlink")}
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+		fd = next
+	}
+
+	return os.NewFile(uintptr(fd), name), nil
+}
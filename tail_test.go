@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func appendFile(t *testing.T, name string, data string) {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTailFiles(t *testing.T) {
+	root, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	logDir := path.Join(root, "var/log/app")
+	os.MkdirAll(logDir, 0755)
+	logFile := path.Join(logDir, "app.log")
+	appendFile(t, logFile, "old\n")
+
+	out := &bytes.Buffer{}
+	tailers := map[string]*fileTailer{}
+	patterns := []string{"/var/log/app/*.log"}
+
+	pollTails(root, patterns, tailers, out, true)
+	if out.Len() != 0 {
+		t.Fatal("existing content should be skipped", out.String())
+	}
+
+	appendFile(t, logFile, "one\ntw")
+	pollTails(root, patterns, tailers, out, false)
+	appendFile(t, logFile, "o\n")
+	pollTails(root, patterns, tailers, out, false)
+
+	/* Truncate */
+	ioutil.WriteFile(logFile, []byte("three\n"), 0644)
+	pollTails(root, patterns, tailers, out, false)
+
+	/* Rotate */
+	appendFile(t, logFile, "four\n")
+	os.Rename(logFile, logFile+".1")
+	appendFile(t, logFile, "five\n")
+	pollTails(root, patterns, tailers, out, false)
+
+	/* New file */
+	appendFile(t, path.Join(logDir, "other.log"), "six\n")
+	pollTails(root, patterns, tailers, out, false)
+
+	expected := "app.log: one\napp.log: two\napp.log: three\napp.log: four\napp.log: five\nother.log: six\n"
+	if out.String() != expected {
+		t.Fatalf("expected %q got %q", expected, out.String())
+	}
+}
+
+func TestParseTailFile(t *testing.T) {
+	c, err := parseContext([]string{"--tail-file", "/a.log", "--tail-file=/b/*.log", "run"})
+	if err != nil {
+		t.Fatal("failed to parse:", err)
+	}
+
+	if len(c.TailFiles) != 2 || c.TailFiles[0] != "/a.log" || c.TailFiles[1] != "/b/*.log" {
+		t.Fatal("bad tail files", c.TailFiles)
+	}
+}
+
+func TestTailFilesSymlink(t *testing.T) {
+	root, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	/* A file on the "host", outside of the container root */
+	host, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(host)
+	appendFile(t, path.Join(host, "shadow"), "secret\n")
+
+	os.MkdirAll(path.Join(root, "var/log"), 0755)
+	os.Symlink(path.Join(host, "shadow"), path.Join(root, "var/log/escape.log"))
+
+	/* Relative symlinks inside the container keep working */
+	appendFile(t, path.Join(root, "var/log/real"), "")
+	os.Symlink("real", path.Join(root, "var/log/app.log"))
+
+	out := &bytes.Buffer{}
+	tailers := map[string]*fileTailer{}
+	patterns := []string{"/var/log/*.log"}
+
+	pollTails(root, patterns, tailers, out, false)
+	appendFile(t, path.Join(host, "shadow"), "more secret\n")
+	appendFile(t, path.Join(root, "var/log/real"), "one\n")
+	pollTails(root, patterns, tailers, out, false)
+
+	if out.String() != "app.log: one\n" {
+		t.Fatal("should only read files in the container", out.String())
+	}
+
+	if tailers["/var/log/escape.log"].lastErr == "" {
+		t.Fatal("escaping symlink should fail to open")
+	}
+}
+
+func TestOpenNoFollow(t *testing.T) {
+	root, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	os.MkdirAll(path.Join(root, "a/b"), 0755)
+	appendFile(t, path.Join(root, "a/b/c.log"), "ok\n")
+	os.Symlink("b", path.Join(root, "a/link"))
+	os.Symlink("c.log", path.Join(root, "a/b/link.log"))
+
+	dir, err := os.Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+
+	file, err := openNoFollow(int(dir.Fd()), "/a/b/c.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	for _, name := range []string{"/a/link/c.log", "/a/b/link.log", "/../a/link/c.log"} {
+		if _, err := openNoFollow(int(dir.Fd()), name); err == nil {
+			t.Fatal("should refuse the symlink in", name)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+/*
+ * getUnitName finds the systemd unit we are running under by looking at
+ * our own systemd cgroup, which works with both the legacy name=systemd
+ * hierarchy and the unified one.  Returns "" when not run from a service.
+ */
+func getUnitName() string {
+	cgroups, err := getCgroupsForPid(os.Getpid())
+	if err != nil {
+		return ""
+	}
+
+	for _, key := range []string{"name=systemd", ""} {
+		parts := strings.Split(cgroups[key], "/")
+		for i := len(parts) - 1; i >= 0; i-- {
+			if strings.HasSuffix(parts[i], ".service") {
+				return parts[i]
+			}
+		}
+	}
+
+	return ""
+}
+
+/* containerName turns a unit name into a valid container name, [a-zA-Z0-9][a-zA-Z0-9_.-]+ */
+func containerName(unit string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '_', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, unit)
+
+	if len(name) == 0 {
+		return ""
+	}
+
+	if c := name[0]; !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+		name = "x" + name
+	}
+
+	return name
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func withCgroupFile(t *testing.T, content string, f func()) {
+	dir, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := CGROUP_PROC
+	CGROUP_PROC = path.Join(dir, "cgroup-%d")
+	defer func() { CGROUP_PROC = old }()
+
+	err = ioutil.WriteFile(fmt.Sprintf(CGROUP_PROC, os.Getpid()), []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f()
+}
+
+func TestUnitNameLegacy(t *testing.T) {
+	withCgroupFile(t, "2:cpu:/\n1:name=systemd:/system.slice/nginx.service\n", func() {
+		if unit := getUnitName(); unit != "nginx.service" {
+			t.Fatal("bad unit name", unit)
+		}
+	})
+}
+
+func TestUnitNameUnified(t *testing.T) {
+	withCgroupFile(t, "0::/system.slice/app@1.service/payload\n", func() {
+		if unit := getUnitName(); unit != "app@1.service" {
+			t.Fatal("bad unit name", unit)
+		}
+	})
+}
+
+func TestUnitNameNone(t *testing.T) {
+	withCgroupFile(t, "0::/user.slice/user-0.slice/session-1.scope\n", func() {
+		if unit := getUnitName(); unit != "" {
+			t.Fatal("should not find a unit", unit)
+		}
+	})
+}
+
+func TestContainerName(t *testing.T) {
+	for unit, expected := range map[string]string{
+		"nginx.service":           "nginx.service",
+		"app@1.service":           "app_1.service",
+		"foo\\x2dbar@a:b.service": "foo_x2dbar_a_b.service",
+		"_weird.service":          "x_weird.service",
+		"":                        "",
+	} {
+		if name := containerName(unit); name != expected {
+			t.Fatalf("%s: expected %s got %s", unit, expected, name)
+		}
+	}
+}
+
+func TestParseAutoName(t *testing.T) {
+	withCgroupFile(t, "0::/system.slice/app@1.service\n", func() {
+		c, err := parseContext([]string{"run", "nginx"})
+		if err != nil {
+			t.Fatal("failed to parse:", err)
+		}
+
+		if c.Name != "app_1.service" || c.Args[0] != "--name" || c.Args[1] != "app_1.service" {
+			t.Fatal("bad auto name", c.Name, c.Args)
+		}
+
+		c, err = parseContext([]string{"run", "--name", "mine", "nginx"})
+		if err != nil {
+			t.Fatal("failed to parse:", err)
+		}
+
+		if c.Name != "mine" || c.Args[0] != "-d" {
+			t.Fatal("explicit name should be kept", c.Name, c.Args)
+		}
+
+		c, err = parseContext([]string{"--auto-name=false", "run", "nginx"})
+		if err != nil {
+			t.Fatal("failed to parse:", err)
+		}
+
+		if len(c.Name) != 0 {
+			t.Fatal("auto name should be disabled", c.Name)
+		}
+	})
+}
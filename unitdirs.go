@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+/* unitDir is one of the directories systemd creates for the unit, like StateDirectory= */
+type unitDir struct {
+	Name     string
+	Env      string
+	ReadOnly bool
+}
+
+var UNIT_DIRS = []unitDir{
+	{"state", "STATE_DIRECTORY", false},
+	{"cache", "CACHE_DIRECTORY", false},
+	{"logs", "LOGS_DIRECTORY", false},
+	{"runtime", "RUNTIME_DIRECTORY", false},
+	{"configuration", "CONFIGURATION_DIRECTORY", true},
+}
+
+/* parseUnitDirTargets parses --unit-dir-target NAME=PATH */
+func parseUnitDirTargets(targets []string) (map[string]string, error) {
+	ret := map[string]string{}
+	for _, target := range targets {
+		parts := strings.SplitN(target, "=", 2)
+		if len(parts) != 2 || !path.IsAbs(parts[1]) {
+			return nil, fmt.Errorf("invalid unit directory target %s, expected NAME=/path", target)
+		}
+
+		found := false
+		for _, dir := range UNIT_DIRS {
+			found = found || dir.Name == parts[0]
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown unit directory %s in %s", parts[0], target)
+		}
+
+		ret[parts[0]] = path.Clean(parts[1])
+	}
+	return ret, nil
+}
+
+/* containerOwnership returns the uid and gid to give the directories, if the container user is numeric */
+func containerOwnership(c *Context) (int, int, bool) {
+	user, ok := parseRunArgs(c.Args).Get("user")
+	if !ok {
+		return 0, 0, false
+	}
+
+	uid, gid, err := parseOwner(user)
+	if err != nil {
+		log.Println("Not changing the owner of the unit directories, user", user, "is not numeric")
+		return 0, 0, false
+	}
+
+	return uid, gid, true
+}
+
+/*
+ * unitDirArgs bind mounts every directory systemd created for the unit.  By
+ * default they are mounted at the same path they have on the host, which is
+ * /var/lib/<name> and so on, or below --unit-dir-target if one is given.
+ */
+func unitDirArgs(targets map[string]string) []string {
+	args := []string{}
+
+	for _, dir := range UNIT_DIRS {
+		sources := unitDirs([]string{dir.Env})
+		target, ok := targets[dir.Name]
+
+		for _, source := range sources {
+			mount := source
+			if ok && len(sources) == 1 {
+				mount = target
+			} else if ok {
+				mount = path.Join(target, path.Base(source))
+			}
+
+			volume := source + ":" + mount
+			if dir.ReadOnly {
+				volume += ":ro"
+			}
+			args = append(args, "-v", volume)
+		}
+	}
+
+	return args
+}
+
+/*
+ * privateDir is where the wrapper keeps files only it should read, like the
+ * env file and the credential copies.  That is the unit's RuntimeDirectory=,
+ * unless --mount-unit-dirs hands that to the container, in which case it is
+ * a directory under STATE_DIR.  Returns "" if there is neither.
+ */
+func privateDir(c *Context) (string, error) {
+	if !c.MountUnitDirs {
+		if dirs := unitDirs([]string{"RUNTIME_DIRECTORY"}); len(dirs) > 0 {
+			return dirs[0], nil
+		}
+		return "", nil
+	}
+
+	name := containerName(c.Name)
+	if len(c.Name) == 0 {
+		name = strconv.Itoa(os.Getpid())
+	}
+
+	dir := path.Join(STATE_DIR, "private", name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+/* setupUnitDirs mounts the unit's directories and hands them to the container's user */
+func setupUnitDirs(c *Context) error {
+	if !c.MountUnitDirs {
+		return nil
+	}
+
+	targets, err := parseUnitDirTargets(c.UnitDirTargets)
+	if err != nil {
+		return err
+	}
+
+	logFile := ""
+	if len(c.LogFile) > 0 {
+		logFile, _ = logFilePath(c)
+	}
+
+	if uid, gid, ok := containerOwnership(c); ok && os.Getuid() == 0 {
+		for _, dir := range UNIT_DIRS {
+			if dir.ReadOnly {
+				continue
+			}
+			for _, source := range unitDirs([]string{dir.Env}) {
+				/* The container user could swap our log file for a symlink */
+				if len(logFile) > 0 && underDir(logFile, source) {
+					log.Println("Not changing the owner of", source, "--log-file", logFile, "is written there")
+					continue
+				}
+
+				/*
+				 * Only the directory itself, the container can write below it
+				 * and could swap a directory for a symlink while we walked it
+				 */
+				if err := os.Chown(source, uid, gid); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	c.Args = append(unitDirArgs(targets), c.Args...)
+	return nil
+}
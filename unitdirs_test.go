@@ -0,0 +1,162 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func fileOwner(t *testing.T, file string) (int, int) {
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := info.Sys().(*syscall.Stat_t)
+	return int(stat.Uid), int(stat.Gid)
+}
+
+func withUnitDirs(t *testing.T, fn func(dir string)) {
+	dir, err := ioutil.TempDir("", "systemd-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, unitDir := range UNIT_DIRS {
+		old, set := os.LookupEnv(unitDir.Env)
+		os.Unsetenv(unitDir.Env)
+		if set {
+			defer os.Setenv(unitDir.Env, old)
+		} else {
+			defer os.Unsetenv(unitDir.Env)
+		}
+	}
+
+	fn(dir)
+}
+
+func TestUnitDirArgs(t *testing.T) {
+	withUnitDirs(t, func(dir string) {
+		os.Setenv("STATE_DIRECTORY", "/var/lib/app")
+		os.Setenv("CACHE_DIRECTORY", "/var/cache/app:/var/cache/other")
+		os.Setenv("CONFIGURATION_DIRECTORY", "/etc/app")
+
+		args := unitDirArgs(map[string]string{})
+		expected := []string{
+			"-v", "/var/lib/app:/var/lib/app",
+			"-v", "/var/cache/app:/var/cache/app",
+			"-v", "/var/cache/other:/var/cache/other",
+			"-v", "/etc/app:/etc/app:ro",
+		}
+		if !reflect.DeepEqual(args, expected) {
+			t.Fatal("bad default mounts", args)
+		}
+
+		targets, err := parseUnitDirTargets([]string{"state=/data", "cache=/cache/"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		args = unitDirArgs(targets)
+		expected = []string{
+			"-v", "/var/lib/app:/data",
+			"-v", "/var/cache/app:/cache/app",
+			"-v", "/var/cache/other:/cache/other",
+			"-v", "/etc/app:/etc/app:ro",
+		}
+		if !reflect.DeepEqual(args, expected) {
+			t.Fatal("bad mounts with targets", args)
+		}
+	})
+}
+
+func TestParseUnitDirTargets(t *testing.T) {
+	for _, target := range []string{"state", "state=data", "home=/home"} {
+		if _, err := parseUnitDirTargets([]string{target}); err == nil {
+			t.Fatal("should be invalid", target)
+		}
+	}
+}
+
+func TestSetupUnitDirs(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing owners needs root")
+	}
+
+	withUnitDirs(t, func(dir string) {
+		state := path.Join(dir, "state")
+		config := path.Join(dir, "config")
+		os.Mkdir(state, 0755)
+		os.Mkdir(config, 0755)
+		os.Mkdir(path.Join(state, "db"), 0755)
+		ioutil.WriteFile(path.Join(state, "db", "data"), []byte("x"), 0644)
+		os.Setenv("STATE_DIRECTORY", state)
+		os.Setenv("CONFIGURATION_DIRECTORY", config)
+
+		c := &Context{MountUnitDirs: true, Args: []string{"-d", "--user", "1234:5678", "app"}}
+		if err := setupUnitDirs(c); err != nil {
+			t.Fatal(err)
+		}
+
+		if c.Args[0] != "-v" || c.Args[1] != state+":"+state || c.Args[len(c.Args)-1] != "app" {
+			t.Fatal("bad args", c.Args)
+		}
+
+		if uid, gid := fileOwner(t, state); uid != 1234 || gid != 5678 {
+			t.Fatal("state directory should belong to the container user", uid, gid)
+		}
+
+		if uid, _ := fileOwner(t, path.Join(state, "db", "data")); uid == 1234 {
+			t.Fatal("existing content should be left alone")
+		}
+
+		if uid, _ := fileOwner(t, config); uid == 1234 {
+			t.Fatal("configuration is read only and should be left alone")
+		}
+
+		logs := path.Join(dir, "logs")
+		os.Mkdir(logs, 0755)
+		os.Setenv("LOGS_DIRECTORY", logs)
+
+		c = &Context{MountUnitDirs: true, LogFile: "app.log", Args: []string{"-d", "--user", "1234:5678", "app"}}
+		if err := setupUnitDirs(c); err != nil {
+			t.Fatal(err)
+		}
+
+		if uid, _ := fileOwner(t, logs); uid == 1234 {
+			t.Fatal("the directory with our log file should be left alone")
+		}
+	})
+}
+
+func TestPrivateDir(t *testing.T) {
+	withUnitDirs(t, func(dir string) {
+		runtime := path.Join(dir, "runtime")
+		os.Setenv("RUNTIME_DIRECTORY", runtime)
+
+		old := STATE_DIR
+		STATE_DIR = path.Join(dir, "state")
+		defer func() { STATE_DIR = old }()
+
+		if private, err := privateDir(&Context{}); err != nil || private != runtime {
+			t.Fatal("should use the runtime directory", private, err)
+		}
+
+		/* The runtime directory is in the container, so it isn't private any more */
+		private, err := privateDir(&Context{MountUnitDirs: true, Name: "web"})
+		if err != nil || private != path.Join(STATE_DIR, "private", "web") {
+			t.Fatal("should use the state directory", private, err)
+		}
+		if info, err := os.Stat(private); err != nil || info.Mode().Perm() != 0700 {
+			t.Fatal("private directory should only be readable by the wrapper", info, err)
+		}
+
+		os.Unsetenv("RUNTIME_DIRECTORY")
+		if private, err := privateDir(&Context{}); err != nil || private != "" {
+			t.Fatal("should have no private directory", private, err)
+		}
+	})
+}